@@ -2,68 +2,621 @@ package config
 
 import (
 	"fmt"
-	"gopkg.in/yaml.v2"
 	"os"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"traffic-generator/spec"
 )
 
 type Config struct {
-	APICount     int
-	APIRate      time.Duration
-	CollectorURL string
+	APICount      int           // 0 means no count limit
+	Duration      time.Duration // 0 means no time limit
+	APIRate       time.Duration
+	CollectorURL  string
+	ScenarioFile  string
+	Mode          string // "open" (default), "closed" or "replay"
+	VirtualUsers  int
+	ThinkTime     time.Duration
+	Profile       *spec.Profile
+	Arrival       spec.Arrival
+	MaxInFlight   int    // open mode cap on requests in flight, 0 for no cap
+	Overflow      string // "delay" or "drop" when the cap is reached, "" until MAX_IN_FLIGHT is set
+	ArrivalSeed   int64
+	ArrivalOpts   spec.ArrivalOptions // what Arrival was built from, sent to workers
+	ReportFile    string
+	ReportFormat  string // "json", "csv" or "html"
+	ResultLog     spec.ResultLogOptions
+	NoResultLog   bool
+	Transport     spec.TransportOptions
+	Retry         *spec.RetryPolicy     // nil when retries are off
+	PayloadSize   spec.SizeDistribution // nil keeps bodies at their natural size
+	ReplayFile    string
+	ReplayTarget  string               // scheme and host replayed requests are sent to, the recorded ones when empty
+	ReplaySpeed   float64              // 1 keeps the recorded timing, 0 replays as fast as possible
+	CaptureFile   string               // records every request sent for replay, off when empty
+	Seed          int64                // run seed, 0 when not set
+	Role          string               // "" runs standalone, "coordinator" or "worker" for distributed runs
+	Workers       []string             // worker URLs a coordinator splits the run between
+	WorkerListen  string               // address a worker serves the control protocol on
+	WorkerToken   string               // shared by a coordinator and its workers to authenticate
+	Thresholds    []spec.Threshold     // pass/fail conditions checked at the end of the run
+	Abort         *spec.ThresholdAbort // nil unless THRESHOLDS_ABORT is on
+	MetricsListen string               // address /metrics is served on, off when empty
+	ControlListen string               // address the run control API is served on, off when empty
+	Progress      string               // "" picks "live" on a terminal and "plain" otherwise, or "off"
+	ProgressEvery time.Duration        // time between plain progress lines, 0 for the default
 }
 
 func ReadConfig() (*Config, error) {
-	data, err := os.ReadFile("config.yaml")
-	if err != nil {
-		return nil, err
-	}
+	return ReadConfigWithOverrides(nil)
+}
 
-	var rawConfig map[string]string
-	err = yaml.Unmarshal(data, &rawConfig)
-	if err != nil {
-		return nil, err
+// ReadConfigWithOverrides reads config.yaml with environment variables and then
+// overrides (from command line flags) applied on top, see Load
+func ReadConfigWithOverrides(overrides map[string]string) (*Config, error) {
+	cfg, _, err := Load(LoadOptions{File: DefaultConfigFile, FileRequired: true, Env: os.Environ(), Flags: overrides})
+	return cfg, err
+}
+
+// ConfigParser checks the settings against each other and builds the Config.
+// The error is a ValidationErrors with every rule broken, each under its key.
+func ConfigParser(rawConfig map[string]string) (*Config, error) {
+	cfg, errs := parseConfig(rawConfig)
+	if len(errs) > 0 {
+		return nil, ValidationErrors(errs)
 	}
+	return cfg, nil
+}
 
-	return ConfigParser(rawConfig)
+// workerOnlyKeys shape how requests are sent, which each worker takes from its own config
+var workerOnlyKeys = []string{
+	"PAYLOAD_SIZE", "MAX_IN_FLIGHT", "IN_FLIGHT_OVERFLOW",
+	"HTTP_POOL_SIZE", "HTTP_IDLE_TIMEOUT", "HTTP_TIMEOUT", "HTTP_TLS_HANDSHAKE_TIMEOUT",
+	"HTTP_KEEP_ALIVE", "HTTP2", "HTTP_NEW_CONNECTION_PER_REQUEST",
+	"RETRY_MAX_ATTEMPTS", "RETRY_BACKOFF", "RETRY_MAX_BACKOFF", "RETRY_MAX_RETRY_AFTER",
+	"RETRY_JITTER", "RETRY_ON_STATUS", "RETRY_ON_ERRORS", "RETRY_HONOR_RETRY_AFTER",
+	"RESULT_LOG", "RESULT_LOG_MAX_SIZE", "RESULT_LOG_MAX_FILES", "RESULT_LOG_GZIP",
+	"CAPTURE_FILE", "THRESHOLDS_ABORT",
 }
 
-func ConfigParser(rawConfig map[string]string) (*Config, error) {
-	apiCount, err := strconv.Atoi(rawConfig["NO_OF_API"])
-	if err != nil || apiCount <= 0 || apiCount > 8192 {
-		return nil, fmt.Errorf("invalid NO_OF_API value")
+func parseConfig(rawConfig map[string]string) (*Config, ruleErrors) {
+	var errs ruleErrors
+	profileSpec := rawConfig["LOAD_PROFILE"]
+
+	var duration time.Duration
+	var err error
+	if rawConfig["DURATION"] != "" {
+		duration, err = time.ParseDuration(rawConfig["DURATION"])
+		if err != nil || duration <= 0 {
+			errs.add("DURATION", fmt.Errorf("invalid DURATION value, use a duration like '30m' or '2h'"))
+		}
+	}
+
+	mode := strings.ToLower(rawConfig["MODE"])
+	if mode != "" && mode != "open" && mode != "closed" && mode != "replay" {
+		errs.add("MODE", fmt.Errorf("invalid MODE value, use 'open', 'closed' or 'replay'"))
+	}
+
+	// Workers get their load from the coordinator, so they need no count, rate or target
+	role := strings.ToLower(rawConfig["ROLE"])
+	worker := role == "worker"
+	var workers []string
+	var workerListen string
+	switch role {
+	case "":
+	case "coordinator":
+		for _, url := range strings.Split(rawConfig["WORKERS"], ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				workers = append(workers, url)
+			}
+		}
+		if len(workers) == 0 {
+			errs.add("WORKERS", fmt.Errorf("WORKERS not set, list the worker URLs separated by commas"))
+		}
+		if mode == "replay" {
+			errs.add("ROLE", fmt.Errorf("replay mode is not supported with ROLE coordinator"))
+		}
+		for _, key := range workerOnlyKeys {
+			if rawConfig[key] != "" {
+				errs.add(key, fmt.Errorf("%s is set on each worker, not on the coordinator", key))
+			}
+		}
+	case "worker":
+		workerListen = rawConfig["WORKER_LISTEN"]
+		if workerListen == "" {
+			workerListen = ":7070"
+		}
+	default:
+		errs.add("ROLE", fmt.Errorf("invalid ROLE value, use 'coordinator' or 'worker'"))
+	}
+	// Workers run what they are sent, so only a coordinator with the token may send it
+	if (role == "coordinator" || worker) && rawConfig["WORKER_TOKEN"] == "" {
+		errs.add("WORKER_TOKEN", fmt.Errorf("WORKER_TOKEN not set, give the coordinator and its workers the same secret"))
 	}
 
-	re := regexp.MustCompile(`^(\d+)/([smhSMH])$`)
-	matches := re.FindStringSubmatch(rawConfig["API_RATE"])
-	if len(matches) != 3 {
-		return nil, fmt.Errorf("invalid API_RATE format, use '2/s', '100/m', or '3000/h'")
+	// A run ends after a count, a duration or the end of its load profile or
+	// recording, whichever comes first, so a count is only needed when none of the others is set
+	var apiCount int
+	if (!worker && profileSpec == "" && rawConfig["DURATION"] == "" && mode != "replay") || rawConfig["NO_OF_API"] != "" {
+		apiCount, err = strconv.Atoi(rawConfig["NO_OF_API"])
+		if err != nil || apiCount <= 0 {
+			errs.add("NO_OF_API", fmt.Errorf("invalid NO_OF_API value, set NO_OF_API, DURATION or LOAD_PROFILE"))
+		}
 	}
 
-	rateValue, _ := strconv.Atoi(matches[1])
-	timeUnit := strings.ToLower(matches[2])
+	// Closed-loop runs are paced by the virtual users, profiles by their stages and
+	// replays by the recording, so a rate is optional there
 	var interval time.Duration
-	switch timeUnit {
-	case "s":
-		interval = time.Second / time.Duration(rateValue)
-	case "m":
-		interval = time.Minute / time.Duration(rateValue)
-	case "h":
-		interval = time.Hour / time.Duration(rateValue)
+	if (!worker && mode != "closed" && mode != "replay" && profileSpec == "") || rawConfig["API_RATE"] != "" {
+		interval, err = parseRate(rawConfig["API_RATE"])
+		if err != nil {
+			errs.add("API_RATE", err)
+		}
+	}
+
+	var profile *spec.Profile
+	switch {
+	case profileSpec == "":
+	case mode == "closed" || mode == "replay":
+		errs.add("LOAD_PROFILE", fmt.Errorf("LOAD_PROFILE is only supported in open mode"))
 	default:
-		return nil, fmt.Errorf("unknown time unit")
+		// The profile starts from API_RATE when one is given, otherwise from zero
+		startRate := 0.0
+		if interval > 0 {
+			startRate = float64(time.Second) / float64(interval)
+		}
+		profile, err = spec.ParseProfile(profileSpec, startRate)
+		if err != nil {
+			errs.add("LOAD_PROFILE", fmt.Errorf("invalid LOAD_PROFILE: %w", err))
+		}
 	}
 
-	if rawConfig["COLLECTOR_URL"] == "" {
-		return nil, fmt.Errorf("COLLECTOR_URL not set")
+	// Every random decision of a run flows from SEED; 0 is left for "not set"
+	var seed int64
+	if rawConfig["SEED"] != "" {
+		seed, err = strconv.ParseInt(rawConfig["SEED"], 10, 64)
+		if err != nil || seed == 0 {
+			errs.add("SEED", fmt.Errorf("invalid SEED value, use a non-zero integer"))
+		}
+	}
+
+	arrivalOpts, arrival := parseArrival(rawConfig, mode, seed, &errs)
+
+	// A worker pool caps requests in flight; at the cap requests wait or are dropped
+	var maxInFlight int
+	var overflow string
+	switch {
+	case rawConfig["MAX_IN_FLIGHT"] == "":
+	case mode == "closed" || mode == "replay":
+		errs.add("MAX_IN_FLIGHT", fmt.Errorf("MAX_IN_FLIGHT is only supported in open mode"))
+	default:
+		maxInFlight, err = strconv.Atoi(rawConfig["MAX_IN_FLIGHT"])
+		if err != nil || maxInFlight <= 0 {
+			errs.add("MAX_IN_FLIGHT", fmt.Errorf("invalid MAX_IN_FLIGHT value, use a positive integer"))
+		}
+		overflow = strings.ToLower(rawConfig["IN_FLIGHT_OVERFLOW"])
+		switch overflow {
+		case "":
+			overflow = spec.OverflowDelay
+		case spec.OverflowDelay, spec.OverflowDrop:
+		default:
+			errs.add("IN_FLIGHT_OVERFLOW", fmt.Errorf("invalid IN_FLIGHT_OVERFLOW value, use 'delay' or 'drop'"))
+		}
+	}
+
+	reportFile := rawConfig["REPORT_FILE"]
+	reportFormat := strings.ToLower(rawConfig["REPORT_FORMAT"])
+	if reportFile != "" {
+		switch reportFormat {
+		case "json", "csv", "html":
+		case "":
+			reportFormat, err = spec.ReportFormat(reportFile)
+			if err != nil {
+				errs.add("REPORT_FILE", fmt.Errorf("invalid REPORT_FILE: %w", err))
+			}
+		default:
+			errs.add("REPORT_FORMAT", fmt.Errorf("invalid REPORT_FORMAT value, use 'json', 'csv' or 'html'"))
+		}
+	}
+
+	resultLog := spec.ResultLogOptions{Path: rawConfig["RESULT_LOG"]}
+	noResultLog := strings.EqualFold(resultLog.Path, "off")
+	if noResultLog {
+		resultLog.Path = ""
+	}
+	if rawConfig["RESULT_LOG_MAX_SIZE"] != "" {
+		resultLog.MaxSize, err = parseSize(rawConfig["RESULT_LOG_MAX_SIZE"])
+		if err != nil {
+			errs.add("RESULT_LOG_MAX_SIZE", err)
+		}
+	}
+	if rawConfig["RESULT_LOG_MAX_FILES"] != "" {
+		resultLog.MaxFiles, err = strconv.Atoi(rawConfig["RESULT_LOG_MAX_FILES"])
+		if err != nil || resultLog.MaxFiles <= 0 {
+			errs.add("RESULT_LOG_MAX_FILES", fmt.Errorf("invalid RESULT_LOG_MAX_FILES value"))
+		}
+	}
+	if rawConfig["RESULT_LOG_GZIP"] != "" {
+		resultLog.Gzip, err = strconv.ParseBool(rawConfig["RESULT_LOG_GZIP"])
+		if err != nil {
+			errs.add("RESULT_LOG_GZIP", fmt.Errorf("invalid RESULT_LOG_GZIP value, use 'true' or 'false'"))
+		}
 	}
 
+	transport := parseTransport(rawConfig, &errs)
+	retry := parseRetry(rawConfig, &errs)
+
+	var payloadSize spec.SizeDistribution
+	if rawConfig["PAYLOAD_SIZE"] != "" {
+		payloadSize, err = spec.ParseSizeDistribution(rawConfig["PAYLOAD_SIZE"])
+		if err != nil {
+			errs.add("PAYLOAD_SIZE", fmt.Errorf("invalid PAYLOAD_SIZE value: %w", err))
+		}
+	}
+
+	var virtualUsers int
+	var thinkTime time.Duration
+	if mode == "closed" {
+		virtualUsers, err = strconv.Atoi(rawConfig["VIRTUAL_USERS"])
+		if err != nil || virtualUsers <= 0 {
+			errs.add("VIRTUAL_USERS", fmt.Errorf("invalid VIRTUAL_USERS value"))
+		}
+
+		if rawConfig["THINK_TIME"] != "" {
+			thinkTime, err = time.ParseDuration(rawConfig["THINK_TIME"])
+			if err != nil || thinkTime < 0 {
+				errs.add("THINK_TIME", fmt.Errorf("invalid THINK_TIME value, use a duration like '500ms'"))
+			}
+		}
+	}
+
+	var replaySpeed float64
+	if mode == "replay" {
+		if rawConfig["REPLAY_FILE"] == "" {
+			errs.add("REPLAY_FILE", fmt.Errorf("REPLAY_FILE not set"))
+		}
+		replaySpeed, err = parseReplaySpeed(rawConfig["REPLAY_SPEED"])
+		if err != nil {
+			errs.add("REPLAY_SPEED", err)
+		}
+	}
+
+	progress := strings.ToLower(rawConfig["PROGRESS"])
+	if progress == "auto" {
+		progress = ""
+	}
+	if progress != "" && progress != "live" && progress != "plain" && progress != "off" {
+		errs.add("PROGRESS", fmt.Errorf("invalid PROGRESS value, use 'auto', 'live', 'plain' or 'off'"))
+	}
+	var progressEvery time.Duration
+	if rawConfig["PROGRESS_INTERVAL"] != "" {
+		progressEvery, err = time.ParseDuration(rawConfig["PROGRESS_INTERVAL"])
+		if err != nil || progressEvery <= 0 {
+			errs.add("PROGRESS_INTERVAL", fmt.Errorf("invalid PROGRESS_INTERVAL value, use a duration like '30s'"))
+		}
+	}
+
+	thresholds, abort := parseThresholds(rawConfig, &errs)
+
+	// The control API has no auth, so a bare ":port" binds localhost only;
+	// every interface takes an explicit "0.0.0.0:port"
+	controlListen := rawConfig["CONTROL_LISTEN"]
+	if strings.HasPrefix(controlListen, ":") {
+		controlListen = "localhost" + controlListen
+	}
+
+	// A scenario file or recording may list its own absolute URLs, otherwise the collector is the target
+	if rawConfig["COLLECTOR_URL"] == "" && rawConfig["SCENARIO_FILE"] == "" && mode != "replay" && !worker {
+		errs.add("COLLECTOR_URL", fmt.Errorf("COLLECTOR_URL not set"))
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return &Config{
-		APICount:     apiCount,
-		APIRate:      interval,
-		CollectorURL: rawConfig["COLLECTOR_URL"],
+		APICount:      apiCount,
+		Duration:      duration,
+		APIRate:       interval,
+		CollectorURL:  rawConfig["COLLECTOR_URL"],
+		ScenarioFile:  rawConfig["SCENARIO_FILE"],
+		Mode:          mode,
+		VirtualUsers:  virtualUsers,
+		ThinkTime:     thinkTime,
+		Profile:       profile,
+		Arrival:       arrival,
+		ArrivalSeed:   arrivalOpts.Seed,
+		ArrivalOpts:   arrivalOpts,
+		MaxInFlight:   maxInFlight,
+		Overflow:      overflow,
+		ReportFile:    reportFile,
+		ReportFormat:  reportFormat,
+		ResultLog:     resultLog,
+		NoResultLog:   noResultLog,
+		Transport:     transport,
+		Retry:         retry,
+		PayloadSize:   payloadSize,
+		ReplayFile:    rawConfig["REPLAY_FILE"],
+		ReplayTarget:  rawConfig["REPLAY_TARGET"],
+		ReplaySpeed:   replaySpeed,
+		CaptureFile:   rawConfig["CAPTURE_FILE"],
+		Seed:          seed,
+		Role:          role,
+		Workers:       workers,
+		WorkerListen:  workerListen,
+		WorkerToken:   rawConfig["WORKER_TOKEN"],
+		Thresholds:    thresholds,
+		Abort:         abort,
+		MetricsListen: rawConfig["METRICS_LISTEN"],
+		ControlListen: controlListen,
+		Progress:      progress,
+		ProgressEvery: progressEvery,
 	}, nil
 }
+
+// parseArrival reads ARRIVAL and the settings of the arrival process it names
+func parseArrival(rawConfig map[string]string, mode string, seed int64, errs *ruleErrors) (spec.ArrivalOptions, spec.Arrival) {
+	arrivalOpts := spec.ArrivalOptions{Kind: strings.ToLower(rawConfig["ARRIVAL"])}
+	if arrivalOpts.Kind == "" {
+		return arrivalOpts, nil
+	}
+	if mode == "closed" || mode == "replay" {
+		errs.add("ARRIVAL", fmt.Errorf("ARRIVAL is only supported in open mode"))
+		return arrivalOpts, nil
+	}
+	found := len(*errs)
+
+	// ARRIVAL_SEED pins the arrival process alone, otherwise it follows SEED
+	var err error
+	switch {
+	case rawConfig["ARRIVAL_SEED"] != "":
+		arrivalOpts.Seed, err = strconv.ParseInt(rawConfig["ARRIVAL_SEED"], 10, 64)
+		if err != nil {
+			errs.add("ARRIVAL_SEED", fmt.Errorf("invalid ARRIVAL_SEED value"))
+		}
+	case seed != 0:
+		arrivalOpts.Seed = spec.DeriveSeed(seed, "arrival")
+	default:
+		arrivalOpts.Seed = time.Now().UnixNano()
+	}
+
+	arrivalOpts.Jitter = 0.5
+	if rawConfig["ARRIVAL_JITTER"] != "" {
+		arrivalOpts.Jitter, err = strconv.ParseFloat(rawConfig["ARRIVAL_JITTER"], 64)
+		if err != nil {
+			errs.add("ARRIVAL_JITTER", fmt.Errorf("invalid ARRIVAL_JITTER value"))
+		}
+	}
+
+	if arrivalOpts.Kind == "burst" {
+		arrivalOpts.BurstOn, err = time.ParseDuration(rawConfig["BURST_ON"])
+		if err != nil {
+			errs.add("BURST_ON", fmt.Errorf("invalid BURST_ON value, use a duration like '2s'"))
+		}
+		arrivalOpts.BurstOff, err = time.ParseDuration(rawConfig["BURST_OFF"])
+		if err != nil {
+			errs.add("BURST_OFF", fmt.Errorf("invalid BURST_OFF value, use a duration like '3s'"))
+		}
+	}
+
+	// The process is only built from settings that all read cleanly
+	if len(*errs) > found {
+		return arrivalOpts, nil
+	}
+	arrival, err := spec.NewArrival(arrivalOpts)
+	if err != nil {
+		errs.add("ARRIVAL", fmt.Errorf("invalid ARRIVAL: %w", err))
+	}
+	return arrivalOpts, arrival
+}
+
+// parseThresholds reads THRESHOLDS and, when THRESHOLDS_ABORT is on, the early
+// stop settings. Runs are not judged before THRESHOLDS_ABORT_GRACE (default 10s).
+func parseThresholds(rawConfig map[string]string, errs *ruleErrors) ([]spec.Threshold, *spec.ThresholdAbort) {
+	if rawConfig["THRESHOLDS"] == "" {
+		if rawConfig["THRESHOLDS_ABORT"] != "" {
+			errs.add("THRESHOLDS_ABORT", fmt.Errorf("THRESHOLDS_ABORT needs THRESHOLDS"))
+		}
+		return nil, nil
+	}
+	thresholds, err := spec.ParseThresholds(rawConfig["THRESHOLDS"])
+	if err != nil {
+		errs.add("THRESHOLDS", fmt.Errorf("invalid THRESHOLDS value: %w", err))
+		return nil, nil
+	}
+
+	if rawConfig["THRESHOLDS_ABORT"] == "" {
+		return thresholds, nil
+	}
+	enabled, err := strconv.ParseBool(rawConfig["THRESHOLDS_ABORT"])
+	if err != nil {
+		errs.add("THRESHOLDS_ABORT", fmt.Errorf("invalid THRESHOLDS_ABORT value, use 'true' or 'false'"))
+		return thresholds, nil
+	}
+	if !enabled {
+		return thresholds, nil
+	}
+
+	abort := &spec.ThresholdAbort{Thresholds: thresholds, Grace: 10 * time.Second}
+	if rawConfig["THRESHOLDS_ABORT_GRACE"] != "" {
+		abort.Grace, err = time.ParseDuration(rawConfig["THRESHOLDS_ABORT_GRACE"])
+		if err != nil || abort.Grace < 0 {
+			errs.add("THRESHOLDS_ABORT_GRACE", fmt.Errorf("invalid THRESHOLDS_ABORT_GRACE value, use a duration like '30s'"))
+		}
+	}
+	return thresholds, abort
+}
+
+// parseReplaySpeed reads REPLAY_SPEED: 'original' (default), a multiplier like '2' or '0.5x', or 'max'
+func parseReplaySpeed(speed string) (float64, error) {
+	switch strings.ToLower(speed) {
+	case "", "original":
+		return 1, nil
+	case "max":
+		return 0, nil
+	}
+	multiplier, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(speed), "x"), 64)
+	if err != nil || multiplier <= 0 {
+		return 0, fmt.Errorf("invalid REPLAY_SPEED value, use 'original', 'max' or a multiplier like '2x'")
+	}
+	return multiplier, nil
+}
+
+// parseRetry reads the RETRY_* settings; retries stay off unless RETRY_MAX_ATTEMPTS > 1
+func parseRetry(rawConfig map[string]string, errs *ruleErrors) *spec.RetryPolicy {
+	if rawConfig["RETRY_MAX_ATTEMPTS"] == "" {
+		return nil
+	}
+	attempts, err := strconv.Atoi(rawConfig["RETRY_MAX_ATTEMPTS"])
+	if err != nil || attempts <= 0 {
+		errs.add("RETRY_MAX_ATTEMPTS", fmt.Errorf("invalid RETRY_MAX_ATTEMPTS value"))
+		return nil
+	}
+	if attempts == 1 {
+		return nil
+	}
+
+	policy := &spec.RetryPolicy{
+		MaxAttempts:     attempts,
+		InitialBackoff:  100 * time.Millisecond,
+		MaxBackoff:      10 * time.Second,
+		Jitter:          0.5,
+		Statuses:        spec.DefaultRetryStatuses,
+		ErrorClasses:    spec.DefaultRetryErrors,
+		HonorRetryAfter: true,
+		MaxRetryAfter:   time.Minute,
+	}
+
+	durations := []struct {
+		key    string
+		target *time.Duration
+	}{
+		{"RETRY_BACKOFF", &policy.InitialBackoff},
+		{"RETRY_MAX_BACKOFF", &policy.MaxBackoff},
+		{"RETRY_MAX_RETRY_AFTER", &policy.MaxRetryAfter},
+	}
+	for _, d := range durations {
+		if v := rawConfig[d.key]; v != "" {
+			*d.target, err = time.ParseDuration(v)
+			if err != nil || *d.target < 0 {
+				errs.add(d.key, fmt.Errorf("invalid %s value, use a duration like '200ms'", d.key))
+			}
+		}
+	}
+
+	if v := rawConfig["RETRY_JITTER"]; v != "" {
+		policy.Jitter, err = strconv.ParseFloat(v, 64)
+		if err != nil || policy.Jitter < 0 || policy.Jitter > 1 {
+			errs.add("RETRY_JITTER", fmt.Errorf("invalid RETRY_JITTER value, use a fraction between 0 and 1"))
+		}
+	}
+
+	if v := rawConfig["RETRY_ON_STATUS"]; v != "" {
+		policy.Statuses = map[int]bool{}
+		for _, field := range strings.Split(v, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || status < 100 || status > 599 {
+				errs.add("RETRY_ON_STATUS", fmt.Errorf("invalid RETRY_ON_STATUS value %q", field))
+				continue
+			}
+			policy.Statuses[status] = true
+		}
+	}
+
+	if v := rawConfig["RETRY_ON_ERRORS"]; v != "" {
+		policy.ErrorClasses = map[string]bool{}
+		for _, field := range strings.Split(v, ",") {
+			class := strings.ToLower(strings.TrimSpace(field))
+			if !slices.Contains(retryErrorClasses, class) {
+				errs.add("RETRY_ON_ERRORS", fmt.Errorf("invalid RETRY_ON_ERRORS value %q, use %s", field, strings.Join(retryErrorClasses, ", ")))
+				continue
+			}
+			policy.ErrorClasses[class] = true
+		}
+	}
+
+	if v := rawConfig["RETRY_HONOR_RETRY_AFTER"]; v != "" {
+		policy.HonorRetryAfter, err = strconv.ParseBool(v)
+		if err != nil {
+			errs.add("RETRY_HONOR_RETRY_AFTER", fmt.Errorf("invalid RETRY_HONOR_RETRY_AFTER value, use 'true' or 'false'"))
+		}
+	}
+
+	return policy
+}
+
+// parseTransport reads the HTTP_* settings; unset keys keep the client defaults
+func parseTransport(rawConfig map[string]string, errs *ruleErrors) spec.TransportOptions {
+	var opts spec.TransportOptions
+	var err error
+
+	if v := rawConfig["HTTP_POOL_SIZE"]; v != "" {
+		opts.PoolSize, err = strconv.Atoi(v)
+		if err != nil || opts.PoolSize <= 0 {
+			errs.add("HTTP_POOL_SIZE", fmt.Errorf("invalid HTTP_POOL_SIZE value"))
+		}
+	}
+
+	durations := []struct {
+		key    string
+		target *time.Duration
+	}{
+		{"HTTP_IDLE_TIMEOUT", &opts.IdleTimeout},
+		{"HTTP_TIMEOUT", &opts.Timeout},
+		{"HTTP_TLS_HANDSHAKE_TIMEOUT", &opts.TLSHandshakeTimeout},
+	}
+	for _, d := range durations {
+		if v := rawConfig[d.key]; v != "" {
+			*d.target, err = time.ParseDuration(v)
+			if err != nil || *d.target <= 0 {
+				errs.add(d.key, fmt.Errorf("invalid %s value, use a duration like '30s'", d.key))
+			}
+		}
+	}
+
+	// HTTP_KEEP_ALIVE and HTTP2 default to on, so they are stored inverted
+	flags := []struct {
+		key    string
+		target *bool
+		invert bool
+	}{
+		{"HTTP_KEEP_ALIVE", &opts.DisableKeepAlives, true},
+		{"HTTP2", &opts.DisableHTTP2, true},
+		{"HTTP_NEW_CONNECTION_PER_REQUEST", &opts.NewConnectionPerRequest, false},
+	}
+	for _, f := range flags {
+		if v := rawConfig[f.key]; v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs.add(f.key, fmt.Errorf("invalid %s value, use 'true' or 'false'", f.key))
+				continue
+			}
+			*f.target = b != f.invert
+		}
+	}
+
+	return opts
+}
+
+// parseSize converts sizes like '512', '64KB', '10MB' or '1GB' into bytes
+func parseSize(size string) (int64, error) {
+	value, err := spec.ParseSize(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid RESULT_LOG_MAX_SIZE value, use a size like '10MB'")
+	}
+	return value, nil
+}
+
+// parseRate converts a rate like '5/s' into the interval between requests
+func parseRate(rate string) (time.Duration, error) {
+	perSecond, err := spec.ParseRatePerSecond(rate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid API_RATE format, use '2/s', '100/m', or '3000/h'")
+	}
+	if perSecond <= 0 {
+		return 0, fmt.Errorf("invalid API_RATE format, rate must be greater than zero")
+	}
+	return time.Duration(float64(time.Second) / perSecond), nil
+}
@@ -1,13 +1,33 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
 )
 
+// TestMain runs the tests in a scratch directory: they write and remove
+// config.yaml in the working directory, which would take the shipped one
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "config-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestConfigParser_ValidConfig(t *testing.T) {
 	rawConfig := map[string]string{
 		"NO_OF_API":     "10",
@@ -112,3 +132,673 @@ func TestReadConfig_FileNotFound(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, config)
 }
+
+func TestConfigParser_ClosedMode(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "50",
+		"MODE":          "closed",
+		"VIRTUAL_USERS": "4",
+		"THINK_TIME":    "250ms",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "closed", config.Mode)
+	assert.Equal(t, 4, config.VirtualUsers)
+	assert.Equal(t, 250*time.Millisecond, config.ThinkTime)
+	assert.Zero(t, config.APIRate)
+}
+
+func TestConfigParser_ClosedModeRequiresUsers(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "50",
+		"MODE":          "closed",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.Error(t, err)
+	assert.Nil(t, config)
+	assert.Contains(t, err.Error(), "invalid VIRTUAL_USERS value")
+}
+
+func TestConfigParser_LoadProfile(t *testing.T) {
+	rawConfig := map[string]string{
+		"API_RATE":      "10/s",
+		"LOAD_PROFILE":  "ramp 100/s 2m, hold 10m",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Zero(t, config.APICount)
+	if assert.NotNil(t, config.Profile) {
+		assert.InDelta(t, 10, config.Profile.RateAt(0), 0.001)
+		assert.Equal(t, 12*time.Minute, config.Profile.Duration())
+	}
+}
+
+func TestConfigParser_InvalidLoadProfile(t *testing.T) {
+	rawConfig := map[string]string{
+		"LOAD_PROFILE":  "ramp 100/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.Error(t, err)
+	assert.Nil(t, config)
+	assert.Contains(t, err.Error(), "invalid LOAD_PROFILE")
+}
+
+func TestConfigParser_Arrival(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "10",
+		"API_RATE":      "5/s",
+		"ARRIVAL":       "burst",
+		"ARRIVAL_SEED":  "42",
+		"BURST_ON":      "2s",
+		"BURST_OFF":     "3s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, config.Arrival)
+	assert.Equal(t, int64(42), config.ArrivalSeed)
+
+	// Bursts without a silent phase in between pass the schema as they do NewArrival
+	path := filepath.Join(t.TempDir(), "generator.yaml")
+	os.WriteFile(path, []byte("load:\n  arrival: burst\n  burst_on: 2s\n  burst_off: 0s\n"), 0644)
+	config, _, err = Load(LoadOptions{File: path, Flags: map[string]string{"NO_OF_API": "10", "API_RATE": "5/s", "COLLECTOR_URL": "http://x"}})
+	assert.NoError(t, err)
+	assert.Zero(t, config.ArrivalOpts.BurstOff)
+}
+
+func TestConfigParser_InvalidArrival(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "10",
+		"API_RATE":      "5/s",
+		"ARRIVAL":       "burst",
+		"BURST_ON":      "2s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.Error(t, err)
+	assert.Nil(t, config)
+	assert.Contains(t, err.Error(), "invalid BURST_OFF value")
+}
+
+func TestConfigParser_DurationWithoutCount(t *testing.T) {
+	rawConfig := map[string]string{
+		"DURATION":      "2h",
+		"API_RATE":      "5000/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, config.Duration)
+	assert.Zero(t, config.APICount)
+}
+
+func TestConfigParser_NoRequestCap(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "1000000",
+		"DURATION":      "10m",
+		"API_RATE":      "5/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1000000, config.APICount)
+	assert.Equal(t, 10*time.Minute, config.Duration)
+}
+
+func TestConfigParser_InvalidDuration(t *testing.T) {
+	rawConfig := map[string]string{
+		"DURATION":      "forever",
+		"API_RATE":      "5/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.Error(t, err)
+	assert.Nil(t, config)
+	assert.Contains(t, err.Error(), "invalid DURATION value")
+}
+
+func TestConfigParser_ReportFile(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "10",
+		"API_RATE":      "5/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+		"REPORT_FILE":   "reports/run.csv",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "reports/run.csv", config.ReportFile)
+	assert.Equal(t, "csv", config.ReportFormat)
+
+	rawConfig["REPORT_FILE"] = "reports/run.txt"
+	config, err = ConfigParser(rawConfig)
+	assert.Error(t, err)
+	assert.Nil(t, config)
+}
+
+func TestConfigParser_ResultLog(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":            "10",
+		"API_RATE":             "5/s",
+		"COLLECTOR_URL":        "http://traffic-stats-col:8080/collect",
+		"RESULT_LOG":           "out/results.ndjson.gz",
+		"RESULT_LOG_MAX_SIZE":  "10MB",
+		"RESULT_LOG_MAX_FILES": "3",
+		"RESULT_LOG_GZIP":      "true",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "out/results.ndjson.gz", config.ResultLog.Path)
+	assert.Equal(t, int64(10<<20), config.ResultLog.MaxSize)
+	assert.Equal(t, 3, config.ResultLog.MaxFiles)
+	assert.True(t, config.ResultLog.Gzip)
+
+	rawConfig["RESULT_LOG_MAX_SIZE"] = "huge"
+	config, err = ConfigParser(rawConfig)
+	assert.Error(t, err)
+	assert.Nil(t, config)
+}
+
+func TestConfigParser_Transport(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":                       "10",
+		"API_RATE":                        "5/s",
+		"COLLECTOR_URL":                   "http://traffic-stats-col:8080/collect",
+		"HTTP_POOL_SIZE":                  "20",
+		"HTTP_KEEP_ALIVE":                 "false",
+		"HTTP_TIMEOUT":                    "5s",
+		"HTTP2":                           "false",
+		"HTTP_NEW_CONNECTION_PER_REQUEST": "true",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 20, config.Transport.PoolSize)
+	assert.True(t, config.Transport.DisableKeepAlives)
+	assert.Equal(t, 5*time.Second, config.Transport.Timeout)
+	assert.True(t, config.Transport.DisableHTTP2)
+	assert.True(t, config.Transport.NewConnectionPerRequest)
+
+	rawConfig["HTTP_TIMEOUT"] = "soon"
+	config, err = ConfigParser(rawConfig)
+	assert.Error(t, err)
+	assert.Nil(t, config)
+}
+
+func TestConfigParser_Retry(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":          "10",
+		"API_RATE":           "5/s",
+		"COLLECTOR_URL":      "http://traffic-stats-col:8080/collect",
+		"RETRY_MAX_ATTEMPTS": "4",
+		"RETRY_BACKOFF":      "50ms",
+		"RETRY_ON_STATUS":    "429, 503",
+		"RETRY_ON_ERRORS":    "timeout",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.Retry) {
+		assert.Equal(t, 4, config.Retry.MaxAttempts)
+		assert.Equal(t, 50*time.Millisecond, config.Retry.InitialBackoff)
+		assert.Equal(t, map[int]bool{429: true, 503: true}, config.Retry.Statuses)
+		assert.Equal(t, map[string]bool{"timeout": true}, config.Retry.ErrorClasses)
+		assert.True(t, config.Retry.HonorRetryAfter)
+	}
+
+	rawConfig["RETRY_ON_ERRORS"] = "gremlins"
+	config, err = ConfigParser(rawConfig)
+	assert.Error(t, err)
+	assert.Nil(t, config)
+}
+
+func TestConfigParser_PayloadSize(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "10",
+		"API_RATE":      "5/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+		"PAYLOAD_SIZE":  "uniform 1KB 4KB",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &spec.UniformSize{Min: 1024, Max: 4096}, config.PayloadSize)
+
+	rawConfig["PAYLOAD_SIZE"] = "gaussian 1KB"
+	config, err = ConfigParser(rawConfig)
+	assert.Error(t, err)
+	assert.Nil(t, config)
+}
+
+func TestConfigParser_Replay(t *testing.T) {
+	rawConfig := map[string]string{
+		"MODE":          "replay",
+		"REPLAY_FILE":   "incident.har",
+		"REPLAY_TARGET": "http://staging:8080",
+		"REPLAY_SPEED":  "2x",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "replay", config.Mode)
+	assert.Equal(t, 0, config.APICount)
+	assert.Equal(t, "incident.har", config.ReplayFile)
+	assert.Equal(t, "http://staging:8080", config.ReplayTarget)
+	assert.Equal(t, 2.0, config.ReplaySpeed)
+
+	rawConfig["REPLAY_SPEED"] = "max"
+	config, err = ConfigParser(rawConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, config.ReplaySpeed)
+
+	for key, value := range map[string]string{"REPLAY_SPEED": "slow", "REPLAY_FILE": "", "LOAD_PROFILE": "hold 1m"} {
+		broken := map[string]string{"MODE": "replay", "REPLAY_FILE": "incident.har"}
+		broken[key] = value
+		config, err = ConfigParser(broken)
+		assert.Error(t, err, key)
+		assert.Nil(t, config)
+	}
+}
+
+func TestConfigParser_CaptureFile(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "10",
+		"API_RATE":      "5/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+		"CAPTURE_FILE":  "capture.ndjson",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "capture.ndjson", config.CaptureFile)
+}
+
+func TestConfigParser_Seed(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "10",
+		"API_RATE":      "5/s",
+		"ARRIVAL":       "poisson",
+		"SEED":          "1234",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1234), config.Seed)
+	assert.Equal(t, spec.DeriveSeed(1234, "arrival"), config.ArrivalSeed)
+
+	rawConfig["SEED"] = "0"
+	config, err = ConfigParser(rawConfig)
+	assert.Error(t, err)
+	assert.Nil(t, config)
+}
+
+func TestReadConfigWithOverrides_Seed(t *testing.T) {
+	tempFile, err := createTempConfigFile("NO_OF_API: \"10\"\nAPI_RATE: \"5/s\"\nCOLLECTOR_URL: \"http://localhost:8080\"\n")
+	assert.NoError(t, err)
+	os.Rename(tempFile, "config.yaml")
+	defer os.Remove("config.yaml")
+
+	config, err := ReadConfigWithOverrides(map[string]string{"SEED": "77"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(77), config.Seed)
+
+	// without a seed one is picked so the run can be repeated
+	config, err = ReadConfigWithOverrides(nil)
+	assert.NoError(t, err)
+	assert.NotZero(t, config.Seed)
+}
+
+func TestConfigParser_Roles(t *testing.T) {
+	config, err := ConfigParser(map[string]string{"ROLE": "worker", "WORKER_TOKEN": "s3cret"})
+	assert.NoError(t, err)
+	assert.Equal(t, "worker", config.Role)
+	assert.Equal(t, ":7070", config.WorkerListen)
+	assert.Equal(t, "s3cret", config.WorkerToken)
+
+	config, err = ConfigParser(map[string]string{
+		"ROLE":          "coordinator",
+		"WORKERS":       "http://w1:7070, http://w2:7070,",
+		"WORKER_TOKEN":  "s3cret",
+		"NO_OF_API":     "100",
+		"API_RATE":      "50/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://w1:7070", "http://w2:7070"}, config.Workers)
+
+	for _, rawConfig := range []map[string]string{
+		{"ROLE": "coordinator", "NO_OF_API": "1", "API_RATE": "1/s", "COLLECTOR_URL": "http://x"},
+		{"ROLE": "leader"},
+		{"ROLE": "worker"},
+		{"ROLE": "coordinator", "WORKERS": "http://w1:7070", "MODE": "replay", "REPLAY_FILE": "a.jsonl"},
+		{"ROLE": "coordinator", "WORKERS": "http://w1:7070", "WORKER_TOKEN": "s3cret", "NO_OF_API": "1", "API_RATE": "1/s", "COLLECTOR_URL": "http://x", "RETRY_MAX_ATTEMPTS": "3"},
+	} {
+		config, err = ConfigParser(rawConfig)
+		assert.Error(t, err)
+		assert.Nil(t, config)
+	}
+}
+
+func TestConfigParser_Thresholds(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":        "10",
+		"API_RATE":         "5/s",
+		"COLLECTOR_URL":    "http://traffic-stats-col:8080/collect",
+		"THRESHOLDS":       "p95 < 300ms, error_rate < 1%",
+		"THRESHOLDS_ABORT": "true",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	if assert.Len(t, config.Thresholds, 2) {
+		assert.Equal(t, "p95", config.Thresholds[0].Metric)
+		assert.Equal(t, 0.01, config.Thresholds[1].Value)
+	}
+	if assert.NotNil(t, config.Abort) {
+		assert.Equal(t, 10*time.Second, config.Abort.Grace)
+	}
+
+	for key, value := range map[string]string{
+		"THRESHOLDS":             "p95 ~ 300ms",
+		"THRESHOLDS_ABORT":       "sometimes",
+		"THRESHOLDS_ABORT_GRACE": "soon",
+	} {
+		broken := map[string]string{}
+		for k, v := range rawConfig {
+			broken[k] = v
+		}
+		broken[key] = value
+		config, err = ConfigParser(broken)
+		assert.Error(t, err, key)
+		assert.Nil(t, config)
+	}
+}
+
+func TestConfigParser_MetricsListen(t *testing.T) {
+	config, err := ConfigParser(map[string]string{
+		"NO_OF_API":      "10",
+		"API_RATE":       "5/s",
+		"COLLECTOR_URL":  "http://traffic-stats-col:8080/collect",
+		"METRICS_LISTEN": ":9100",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, ":9100", config.MetricsListen)
+}
+
+func TestConfigParser_ControlListen(t *testing.T) {
+	config, err := ConfigParser(map[string]string{
+		"NO_OF_API":      "10",
+		"API_RATE":       "5/s",
+		"COLLECTOR_URL":  "http://traffic-stats-col:8080/collect",
+		"CONTROL_LISTEN": "localhost:7071",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:7071", config.ControlListen)
+
+	// A bare port stays off other interfaces
+	config, err = ConfigParser(map[string]string{
+		"NO_OF_API":      "10",
+		"API_RATE":       "5/s",
+		"COLLECTOR_URL":  "http://traffic-stats-col:8080/collect",
+		"CONTROL_LISTEN": ":7071",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:7071", config.ControlListen)
+}
+
+func TestConfigParser_Progress(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":         "10",
+		"API_RATE":          "5/s",
+		"COLLECTOR_URL":     "http://traffic-stats-col:8080/collect",
+		"PROGRESS":          "Plain",
+		"PROGRESS_INTERVAL": "30s",
+	}
+
+	config, err := ConfigParser(rawConfig)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", config.Progress)
+	assert.Equal(t, 30*time.Second, config.ProgressEvery)
+
+	rawConfig["PROGRESS"] = "fancy"
+	config, err = ConfigParser(rawConfig)
+	assert.Error(t, err)
+	assert.Nil(t, config)
+}
+
+func TestLoad_Layers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "generator.yaml")
+	os.WriteFile(path, []byte("NO_OF_API: \"10\"\nAPI_RATE: \"5/s\"\nCOLLECTOR_URL: \"http://file:8080/collect\"\n"), 0644)
+
+	cfg, eff, err := Load(LoadOptions{
+		File:  path,
+		Env:   []string{"API_URL=http://env:8080/collect", "TRAFFIC_API_RATE=20/s", "PATH=/usr/bin", "MODE=production", "SEED=x"},
+		Flags: map[string]string{"API_RATE": "40/s", "SEED": "9"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 10, cfg.APICount)
+	assert.Equal(t, "http://env:8080/collect", cfg.CollectorURL)
+	assert.Equal(t, 25*time.Millisecond, cfg.APIRate)
+	assert.Equal(t, int64(9), cfg.Seed)
+	assert.Equal(t, "open", cfg.Mode, "MODE without the TRAFFIC_ prefix is not ours")
+	assert.Equal(t, map[string]string{
+		"NO_OF_API": SourceFile, "COLLECTOR_URL": SourceEnv, "API_RATE": SourceFlag, "SEED": SourceFlag, "MODE": SourceDefault,
+	}, map[string]string{
+		"NO_OF_API": eff.Sources["NO_OF_API"], "COLLECTOR_URL": eff.Sources["COLLECTOR_URL"], "API_RATE": eff.Sources["API_RATE"],
+		"SEED": eff.Sources["SEED"], "MODE": eff.Sources["MODE"],
+	})
+	assert.NotContains(t, eff.Values, "PATH")
+
+	// COLLECTOR_URL itself wins over the API_URL alias
+	cfg, _, err = Load(LoadOptions{File: path, Env: []string{"API_URL=http://alias", "TRAFFIC_COLLECTOR_URL=http://direct"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://direct", cfg.CollectorURL)
+}
+
+func TestLoad_ConfigFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+	// a missing file is skipped unless it was asked for
+	cfg, eff, err := Load(LoadOptions{File: missing, Env: []string{"TRAFFIC_NO_OF_API=3", "TRAFFIC_API_RATE=1/s", "API_URL=http://env"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, cfg.APICount)
+	assert.Empty(t, eff.File)
+
+	_, _, err = Load(LoadOptions{File: missing, FileRequired: true})
+	assert.Error(t, err)
+}
+
+func TestEffective_PrintRedactsSecrets(t *testing.T) {
+	eff := &Effective{
+		Values:  map[string]string{"AUTH_TOKEN": "abc123", "WORKERS": "http://w1:7070,http://user:hunter2@w2:7070", "NO_OF_API": "10"},
+		Sources: map[string]string{"AUTH_TOKEN": SourceEnv, "WORKERS": SourceFile, "NO_OF_API": SourceFile},
+	}
+
+	var out strings.Builder
+	eff.Print(&out)
+
+	assert.NotContains(t, out.String(), "abc123")
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.Contains(t, out.String(), "http://user:xxxxx@w2:7070")
+	assert.Contains(t, out.String(), `"10"`)
+}
+
+func TestLoad_NestedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "generator.yaml")
+	os.WriteFile(path, []byte(`run:
+  count: 10
+load:
+  rate: 5/s
+targets:
+  collector_url: http://file:8080/collect
+transport:
+  retry:
+    max_attempts: 3
+    on_status: [502, 503]
+`), 0644)
+
+	cfg, eff, err := Load(LoadOptions{File: path})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 10, cfg.APICount)
+	assert.Equal(t, 200*time.Millisecond, cfg.APIRate)
+	assert.Equal(t, 3, cfg.Retry.MaxAttempts)
+	assert.Equal(t, map[int]bool{502: true, 503: true}, cfg.Retry.Statuses)
+	assert.Equal(t, "load.rate", eff.Paths["API_RATE"])
+	assert.Equal(t, 4, eff.Lines["API_RATE"])
+}
+
+func TestLoad_CollectsEveryProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "generator.yaml")
+	os.WriteFile(path, []byte(`run:
+  count: ten
+load:
+  rate: 10x/s
+  bogus: 1
+targets:
+  collector_url: http://file:8080/collect
+output: json
+`), 0644)
+
+	_, _, err := Load(LoadOptions{File: path, Flags: map[string]string{"HTTP_TIMEOUT": "soon", "NOT_A_KEY": "1"}})
+
+	var problems ValidationErrors
+	assert.ErrorAs(t, err, &problems)
+	var got []string
+	for _, p := range problems {
+		got = append(got, fmt.Sprintf("%s %d %s", p.Source, p.Line, p.Path))
+	}
+	assert.Equal(t, []string{
+		"file 2 run.count",
+		"file 4 load.rate",
+		"file 5 load.bogus",
+		"file 8 output",
+		"flag 0 HTTP_TIMEOUT",
+		"flag 0 NOT_A_KEY",
+	}, got)
+	assert.Contains(t, err.Error(), path+`:2: run.count = "ten": must be a positive integer`)
+	assert.Contains(t, err.Error(), `flag HTTP_TIMEOUT = "soon": must be a positive duration`)
+}
+
+func TestLoad_TypeAndCrossFieldProblemsTogether(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "generator.yaml")
+	os.WriteFile(path, []byte("run:\n  count: abc\nload:\n  rate: 5/s\n"), 0644)
+
+	_, _, err := Load(LoadOptions{File: path})
+
+	// The missing collector is reported alongside the bad count, which only gets its type error
+	var problems ValidationErrors
+	assert.ErrorAs(t, err, &problems)
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Path)
+	}
+	assert.Equal(t, []string{"run.count", "targets.collector_url"}, got)
+	assert.Contains(t, err.Error(), "COLLECTOR_URL not set")
+}
+
+func TestLoad_CrossFieldProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "generator.yaml")
+	os.WriteFile(path, []byte("run:\n  mode: closed\n  count: 10\ntargets:\n  collector_url: http://file:8080/collect\n"), 0644)
+
+	_, _, err := Load(LoadOptions{File: path})
+
+	var problems ValidationErrors
+	assert.ErrorAs(t, err, &problems)
+	assert.Len(t, problems, 1)
+	assert.Equal(t, "load.virtual_users", problems[0].Path)
+	assert.Contains(t, err.Error(), "invalid VIRTUAL_USERS value")
+}
+
+func TestLoad_CrossFieldProblemsNameTheirField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "generator.yaml")
+	os.WriteFile(path, []byte("load:\n  rate: 5/s\n"), 0644)
+
+	_, _, err := Load(LoadOptions{File: path, Env: []string{"TRAFFIC_MODE=closed", "TRAFFIC_REPORT_FILE=out.txt"}})
+
+	// Every broken rule is reported, under the key it is about
+	var problems ValidationErrors
+	assert.ErrorAs(t, err, &problems)
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Source+" "+p.Path)
+	}
+	assert.Equal(t, []string{
+		" run.count",
+		"env TRAFFIC_REPORT_FILE",
+		" load.virtual_users",
+		" targets.collector_url",
+	}, got)
+	assert.Contains(t, err.Error(), "run.count: invalid NO_OF_API value")
+}
+
+func TestConfigParser_MaxInFlight(t *testing.T) {
+	rawConfig := map[string]string{
+		"NO_OF_API":     "10",
+		"API_RATE":      "5/s",
+		"COLLECTOR_URL": "http://traffic-stats-col:8080/collect",
+		"MAX_IN_FLIGHT": "500",
+	}
+
+	config, err := ConfigParser(rawConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 500, config.MaxInFlight)
+	assert.Equal(t, spec.OverflowDelay, config.Overflow)
+
+	rawConfig["IN_FLIGHT_OVERFLOW"] = "DROP"
+	config, err = ConfigParser(rawConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, spec.OverflowDrop, config.Overflow)
+
+	rawConfig["IN_FLIGHT_OVERFLOW"] = "queue"
+	_, err = ConfigParser(rawConfig)
+	assert.ErrorContains(t, err, "invalid IN_FLIGHT_OVERFLOW value")
+
+	delete(rawConfig, "IN_FLIGHT_OVERFLOW")
+	rawConfig["MAX_IN_FLIGHT"] = "0"
+	_, err = ConfigParser(rawConfig)
+	assert.ErrorContains(t, err, "invalid MAX_IN_FLIGHT value")
+
+	rawConfig["MAX_IN_FLIGHT"] = "5"
+	rawConfig["MODE"] = "closed"
+	rawConfig["VIRTUAL_USERS"] = "5"
+	_, err = ConfigParser(rawConfig)
+	assert.ErrorContains(t, err, "MAX_IN_FLIGHT is only supported in open mode")
+}
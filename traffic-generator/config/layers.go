@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Defaults are the values of keys no other layer sets
+var Defaults = map[string]string{
+	"MODE":                   "open",
+	"ARRIVAL_JITTER":         "0.5",
+	"REPLAY_SPEED":           "original",
+	"WORKER_LISTEN":          ":7070",
+	"PROGRESS":               "auto",
+	"PROGRESS_INTERVAL":      "10s",
+	"THRESHOLDS_ABORT_GRACE": "10s",
+}
+
+// EnvPrefix is put before a key to set it from the environment, TRAFFIC_MODE
+// for MODE, so variables meant for other programs are never picked up
+const EnvPrefix = "TRAFFIC_"
+
+// envAliases maps environment variables the deployment manifests set to the keys they mean
+var envAliases = map[string]string{
+	"API_URL": "COLLECTOR_URL",
+}
+
+// DefaultConfigFile is read when no config file is given
+const DefaultConfigFile = "config.yaml"
+
+// Layer sources, lowest priority first
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+	SourceRandom  = "random" // a SEED picked because no layer set one
+)
+
+// LoadOptions say where each configuration layer comes from
+type LoadOptions struct {
+	File         string            // config file, DefaultConfigFile when empty
+	FileRequired bool              // fail when the file is missing instead of skipping it
+	Env          []string          // KEY=VALUE pairs, usually os.Environ()
+	Flags        map[string]string // values from the command line, the highest priority
+}
+
+// Effective is the merged configuration with the layer each value came from
+type Effective struct {
+	File     string // the file read, empty when there was none
+	Values   map[string]string
+	Sources  map[string]string
+	Paths    map[string]string // how file values were written, a flat key or a nested path, and the variable env values came from
+	Lines    map[string]int    // file line of each file value
+	Problems []FieldError      // unknown keys and malformed entries found while reading
+}
+
+// Resolve merges built-in defaults, the config file, environment variables and
+// flags, each layer overriding the ones before it. Empty values don't override.
+// Problems in the values are kept for Validate, only unreadable files fail here.
+func Resolve(opts LoadOptions) (*Effective, error) {
+	eff := &Effective{
+		Values:  map[string]string{},
+		Sources: map[string]string{},
+		Paths:   map[string]string{},
+		Lines:   map[string]int{},
+	}
+	set := func(key, value, source string) {
+		if value != "" {
+			eff.Values[key], eff.Sources[key] = value, source
+		}
+	}
+
+	for key, value := range Defaults {
+		set(key, value, SourceDefault)
+	}
+
+	path := opts.File
+	if path == "" {
+		path = DefaultConfigFile
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		eff.File = path
+		if err := eff.readFile(data); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+		}
+	case opts.FileRequired || !os.IsNotExist(err):
+		return nil, err
+	}
+
+	env := map[string]string{}
+	for _, pair := range opts.Env {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			env[key] = value
+		}
+	}
+	setEnv := func(key, name string) {
+		if env[name] != "" {
+			set(key, env[name], SourceEnv)
+			eff.Paths[key] = name
+		}
+	}
+	for alias, key := range envAliases {
+		setEnv(key, alias)
+	}
+	for _, f := range Schema {
+		setEnv(f.Key, EnvPrefix+f.Key)
+	}
+
+	for key, value := range opts.Flags {
+		if _, ok := fieldByKey[key]; !ok {
+			eff.Problems = append(eff.Problems, FieldError{Source: SourceFlag, Path: key, Err: fmt.Errorf("unknown key")})
+			continue
+		}
+		set(key, value, SourceFlag)
+	}
+
+	// A run without a SEED gets a random one so it can still be reproduced
+	if eff.Values["SEED"] == "" {
+		set("SEED", strconv.FormatInt(time.Now().UnixNano(), 10), SourceRandom)
+	}
+	return eff, nil
+}
+
+// Load resolves the configuration layers, validates every value and parses the result
+func Load(opts LoadOptions) (*Config, *Effective, error) {
+	eff, err := Resolve(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, problems := eff.parse()
+	if len(problems) > 0 {
+		return nil, eff, problems
+	}
+	return cfg, eff, nil
+}
+
+// secretKey matches keys whose values are never printed
+var secretKey = regexp.MustCompile(`PASSWORD|SECRET|TOKEN|API_KEY|CREDENTIAL`)
+
+// Redacted returns value as it may be printed: secrets are masked, and so are
+// passwords in URLs
+func Redacted(key, value string) string {
+	if secretKey.MatchString(key) {
+		return "******"
+	}
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		if u, err := url.Parse(strings.TrimSpace(part)); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				parts[i] = strings.Replace(part, strings.TrimSpace(part), u.Redacted(), 1)
+			}
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Print writes every effective value with its source, secrets redacted
+func (e *Effective) Print(w io.Writer) {
+	if e.File != "" {
+		fmt.Fprintf(w, "# config file: %s\n", e.File)
+	}
+	keys := make([]string, 0, len(e.Values))
+	for key := range e.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s:\t%q\t# %s\n", key, Redacted(key, e.Values[key]), e.Sources[key])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"traffic-generator/spec"
+)
+
+// fieldType names the kind of value a setting takes and checks it
+type fieldType struct {
+	name  string
+	check func(value string) error
+}
+
+// Field is one setting: its path in the nested config file, the flat key used
+// in flat files, environment variables and -set flags, and its type
+type Field struct {
+	Path string // e.g. "load.rate"
+	Key  string // e.g. "API_RATE"
+	Type fieldType
+}
+
+// Schema lists every setting the generator reads, by section
+var Schema = []Field{
+	{"run.mode", "MODE", oneOf("open", "closed", "replay")},
+	{"run.count", "NO_OF_API", positiveIntType},
+	{"run.duration", "DURATION", positiveDurationType},
+	{"run.seed", "SEED", seedType},
+	{"run.role", "ROLE", oneOf("coordinator", "worker")},
+	{"run.workers", "WORKERS", listOf(urlType)},
+	{"run.worker_listen", "WORKER_LISTEN", addressType},
+	{"run.worker_token", "WORKER_TOKEN", textType},
+	{"run.control_listen", "CONTROL_LISTEN", addressType},
+
+	{"load.rate", "API_RATE", rateType},
+	{"load.profile", "LOAD_PROFILE", profileType},
+	{"load.arrival", "ARRIVAL", oneOf("constant", "poisson", "jitter", "burst")},
+	{"load.arrival_seed", "ARRIVAL_SEED", intType},
+	{"load.arrival_jitter", "ARRIVAL_JITTER", fractionType},
+	{"load.burst_on", "BURST_ON", positiveDurationType},
+	{"load.burst_off", "BURST_OFF", durationType},
+	{"load.virtual_users", "VIRTUAL_USERS", positiveIntType},
+	{"load.think_time", "THINK_TIME", durationType},
+	{"load.payload_size", "PAYLOAD_SIZE", sizeDistributionType},
+	{"load.max_in_flight", "MAX_IN_FLIGHT", positiveIntType},
+	{"load.in_flight_overflow", "IN_FLIGHT_OVERFLOW", oneOf(spec.OverflowDelay, spec.OverflowDrop)},
+
+	{"targets.collector_url", "COLLECTOR_URL", urlType},
+	{"targets.scenario_file", "SCENARIO_FILE", fileType},
+	{"targets.replay.file", "REPLAY_FILE", fileType},
+	{"targets.replay.speed", "REPLAY_SPEED", replaySpeedType},
+	{"targets.replay.target", "REPLAY_TARGET", urlType},
+
+	{"transport.pool_size", "HTTP_POOL_SIZE", positiveIntType},
+	{"transport.idle_timeout", "HTTP_IDLE_TIMEOUT", positiveDurationType},
+	{"transport.timeout", "HTTP_TIMEOUT", positiveDurationType},
+	{"transport.tls_handshake_timeout", "HTTP_TLS_HANDSHAKE_TIMEOUT", positiveDurationType},
+	{"transport.keep_alive", "HTTP_KEEP_ALIVE", boolType},
+	{"transport.http2", "HTTP2", boolType},
+	{"transport.new_connection_per_request", "HTTP_NEW_CONNECTION_PER_REQUEST", boolType},
+	{"transport.retry.max_attempts", "RETRY_MAX_ATTEMPTS", positiveIntType},
+	{"transport.retry.backoff", "RETRY_BACKOFF", durationType},
+	{"transport.retry.max_backoff", "RETRY_MAX_BACKOFF", durationType},
+	{"transport.retry.max_retry_after", "RETRY_MAX_RETRY_AFTER", durationType},
+	{"transport.retry.jitter", "RETRY_JITTER", fractionType},
+	{"transport.retry.on_status", "RETRY_ON_STATUS", listOf(statusType)},
+	{"transport.retry.on_errors", "RETRY_ON_ERRORS", listOf(oneOf(retryErrorClasses...))},
+	{"transport.retry.honor_retry_after", "RETRY_HONOR_RETRY_AFTER", boolType},
+
+	{"output.report_file", "REPORT_FILE", textType},
+	{"output.report_format", "REPORT_FORMAT", oneOf("json", "csv", "html")},
+	{"output.result_log.path", "RESULT_LOG", textType},
+	{"output.result_log.max_size", "RESULT_LOG_MAX_SIZE", sizeType},
+	{"output.result_log.max_files", "RESULT_LOG_MAX_FILES", positiveIntType},
+	{"output.result_log.gzip", "RESULT_LOG_GZIP", boolType},
+	{"output.capture_file", "CAPTURE_FILE", textType},
+	{"output.metrics_listen", "METRICS_LISTEN", addressType},
+	{"output.progress", "PROGRESS", oneOf("auto", "live", "plain", "off")},
+	{"output.progress_interval", "PROGRESS_INTERVAL", positiveDurationType},
+	{"output.thresholds", "THRESHOLDS", thresholdsType},
+	{"output.thresholds_abort", "THRESHOLDS_ABORT", boolType},
+	{"output.thresholds_abort_grace", "THRESHOLDS_ABORT_GRACE", durationType},
+}
+
+// fieldByKey and fieldByPath index Schema
+var fieldByKey, fieldByPath = indexSchema()
+
+func indexSchema() (map[string]Field, map[string]Field) {
+	byKey, byPath := map[string]Field{}, map[string]Field{}
+	for _, f := range Schema {
+		byKey[f.Key], byPath[f.Path] = f, f
+	}
+	return byKey, byPath
+}
+
+// isSection reports whether path is a section of the nested schema, like "transport.retry"
+func isSection(path string) bool {
+	for _, f := range Schema {
+		if strings.HasPrefix(f.Path, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// retryErrorClasses are the values RETRY_ON_ERRORS takes
+var retryErrorClasses = []string{"all", "timeout", "connection_refused", "connection_reset", "dns", "eof", "other"}
+
+var (
+	textType = fieldType{"string", func(string) error { return nil }}
+
+	intType = fieldType{"integer", func(v string) error {
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		return nil
+	}}
+
+	positiveIntType = fieldType{"positive integer", func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("must be a positive integer")
+		}
+		return nil
+	}}
+
+	seedType = fieldType{"non-zero integer", func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n == 0 {
+			return fmt.Errorf("must be a non-zero integer")
+		}
+		return nil
+	}}
+
+	fractionType = fieldType{"fraction", func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return fmt.Errorf("must be a fraction between 0 and 1")
+		}
+		return nil
+	}}
+
+	boolType = fieldType{"bool", func(v string) error {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("must be 'true' or 'false'")
+		}
+		return nil
+	}}
+
+	durationType = fieldType{"duration", func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("must be a duration like '500ms' or '2m'")
+		}
+		return nil
+	}}
+
+	positiveDurationType = fieldType{"positive duration", func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("must be a positive duration like '30s' or '2h'")
+		}
+		return nil
+	}}
+
+	rateType = fieldType{"rate", func(v string) error {
+		_, err := parseRate(v)
+		return err
+	}}
+
+	sizeType = fieldType{"size", func(v string) error {
+		if _, err := spec.ParseSize(v); err != nil {
+			return fmt.Errorf("must be a size like '10MB'")
+		}
+		return nil
+	}}
+
+	sizeDistributionType = fieldType{"size distribution", func(v string) error {
+		_, err := spec.ParseSizeDistribution(v)
+		return err
+	}}
+
+	profileType = fieldType{"load profile", func(v string) error {
+		_, err := spec.ParseProfile(v, 0)
+		return err
+	}}
+
+	thresholdsType = fieldType{"thresholds", func(v string) error {
+		_, err := spec.ParseThresholds(v)
+		return err
+	}}
+
+	replaySpeedType = fieldType{"replay speed", func(v string) error {
+		_, err := parseReplaySpeed(v)
+		return err
+	}}
+
+	urlType = fieldType{"url", func(v string) error {
+		u, err := url.Parse(strings.TrimSpace(v))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("must be an absolute URL like 'http://host:8080'")
+		}
+		return nil
+	}}
+
+	addressType = fieldType{"address", func(v string) error {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return fmt.Errorf("must be an address like ':9100' or 'host:9100'")
+		}
+		return nil
+	}}
+
+	statusType = fieldType{"status code", func(v string) error {
+		status, err := strconv.Atoi(v)
+		if err != nil || status < 100 || status > 599 {
+			return fmt.Errorf("%q is not an HTTP status code", v)
+		}
+		return nil
+	}}
+
+	fileType = fieldType{"file", func(v string) error {
+		if _, err := os.Stat(v); err != nil {
+			return fmt.Errorf("file %s not found", v)
+		}
+		return nil
+	}}
+)
+
+func oneOf(values ...string) fieldType {
+	return fieldType{"one of " + strings.Join(values, ", "), func(v string) error {
+		for _, allowed := range values {
+			if strings.EqualFold(v, allowed) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", v, strings.Join(values, ", "))
+	}}
+}
+
+// listOf takes comma-separated values (or a YAML list in a config file) of one type
+func listOf(item fieldType) fieldType {
+	return fieldType{"list of " + item.name, func(v string) error {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			if err := item.check(part); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
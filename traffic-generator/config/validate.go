@@ -0,0 +1,199 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError is one problem with a setting, located in the layer that set it
+type FieldError struct {
+	Source string // SourceFile, SourceEnv or SourceFlag, empty when no layer set the field
+	File   string
+	Line   int
+	Path   string // as written: a nested path in a file, the flat key elsewhere
+	Value  string // redacted, empty when the problem is not the value
+	Err    error
+}
+
+func (e FieldError) Error() string {
+	var b strings.Builder
+	switch e.Source {
+	case SourceFile:
+		fmt.Fprintf(&b, "%s:%d: ", e.File, e.Line)
+	case SourceEnv, SourceFlag, SourceDefault:
+		b.WriteString(e.Source + " ")
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path)
+		if e.Value != "" {
+			fmt.Fprintf(&b, " = %q", e.Value)
+		}
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+// ValidationErrors are all the problems found in a configuration
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	lines := make([]string, len(v))
+	for i, e := range v {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readFile reads a config file written with flat keys (API_RATE: 2/s), nested
+// sections (load: {rate: 2/s}) or a mix of both, keeping the line of every value
+func (e *Effective) readFile(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil // empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected settings or sections at the top level", root.Line)
+	}
+	e.readSection(root, "")
+	return nil
+}
+
+func (e *Effective) readSection(node *yaml.Node, section string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i], node.Content[i+1]
+		path := name.Value
+		if section != "" {
+			path = section + "." + name.Value
+		}
+		problem := func(err error) {
+			e.Problems = append(e.Problems, FieldError{Source: SourceFile, File: e.File, Line: name.Line, Path: path, Err: err})
+		}
+
+		field, ok := fieldByPath[path]
+		if !ok && section == "" {
+			field, ok = fieldByKey[path]
+		}
+		switch {
+		case ok:
+			v, err := scalarValue(value)
+			if err != nil {
+				problem(err)
+				continue
+			}
+			if v != "" {
+				e.Values[field.Key], e.Sources[field.Key] = v, SourceFile
+				e.Paths[field.Key], e.Lines[field.Key] = path, value.Line
+			}
+		case isSection(path):
+			if value.Kind != yaml.MappingNode {
+				problem(fmt.Errorf("is a section, expected settings under it"))
+				continue
+			}
+			e.readSection(value, path)
+		default:
+			problem(fmt.Errorf("unknown field"))
+		}
+	}
+}
+
+// scalarValue returns a setting as the flat string the parser takes; lists are
+// joined with commas
+func scalarValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("expected a list of values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("expected a value, not a section")
+}
+
+// Validate checks every value against its type and the settings against each
+// other. It returns every problem found.
+func (e *Effective) Validate() ValidationErrors {
+	_, problems := e.parse()
+	return problems
+}
+
+func (e *Effective) parse() (*Config, ValidationErrors) {
+	problems := append(ValidationErrors(nil), e.Problems...)
+	failed := make(map[string]bool)
+	for _, f := range Schema {
+		value, ok := e.Values[f.Key]
+		if !ok {
+			continue
+		}
+		if err := f.Type.check(value); err != nil {
+			problem := e.fieldError(f.Key, err)
+			problem.Value = Redacted(f.Key, value)
+			problems = append(problems, problem)
+			failed[f.Key] = true
+		}
+	}
+	sourceOrder := map[string]int{SourceFile: 0, SourceEnv: 1, SourceFlag: 2}
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if sourceOrder[a.Source] != sourceOrder[b.Source] {
+			return sourceOrder[a.Source] < sourceOrder[b.Source]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Path < b.Path
+	})
+
+	// Rules between settings, like a count or a duration being required, are
+	// ConfigParser's; a key with the wrong type was reported above already
+	cfg, errs := parseConfig(e.Values)
+	for _, err := range errs {
+		if !failed[err.Path] {
+			problems = append(problems, e.fieldError(err.Path, err.Err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return cfg, nil
+}
+
+// fieldError locates key in the layer that set it
+func (e *Effective) fieldError(key string, err error) FieldError {
+	problem := FieldError{Source: e.Sources[key], Path: key, Err: err}
+	switch problem.Source {
+	case SourceFile:
+		problem.File, problem.Line, problem.Path = e.File, e.Lines[key], e.Paths[key]
+	case SourceEnv:
+		problem.Path = e.Paths[key]
+	case "":
+		if f, ok := fieldByKey[key]; ok {
+			problem.Path = f.Path
+		}
+	}
+	return problem
+}
+
+// ruleErrors collects the rules between settings a configuration breaks, each
+// with the key it is reported under
+type ruleErrors []FieldError
+
+func (r *ruleErrors) add(key string, err error) {
+	*r = append(*r, FieldError{Path: key, Err: err})
+}
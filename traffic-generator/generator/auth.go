@@ -0,0 +1,259 @@
+package generator
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthProvider adds credentials to an outgoing request. body is the request
+// payload, passed separately so signers don't have to re-read req.Body.
+type AuthProvider interface {
+	Authenticate(req *http.Request, body []byte) error
+}
+
+// AuthConfig is the auth section of a scenario or endpoint. String values may
+// reference environment variables as ${NAME} so secrets stay out of the file.
+type AuthConfig struct {
+	Type string `yaml:"type"` // "basic", "bearer", "oauth2" or "hmac"
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	Token string `yaml:"token"`
+
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+
+	KeyID  string `yaml:"key_id"`
+	Secret string `yaml:"secret"`
+	Header string `yaml:"header"` // header carrying the HMAC signature, default Authorization
+
+	getenv func(string) string // where ${NAME} is looked up, the process environment when nil
+}
+
+// expandable returns the values that may reference environment variables
+func (c *AuthConfig) expandable() []string {
+	return []string{c.Username, c.Password, c.Token, c.TokenURL, c.ClientID, c.ClientSecret, c.KeyID, c.Secret}
+}
+
+// NewAuthProvider builds the provider described by c
+func NewAuthProvider(c *AuthConfig) (AuthProvider, error) {
+	getenv := c.getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	env := func(v string) string { return os.Expand(v, getenv) }
+
+	// Required values are checked after ${NAME} expansion, so a reference to
+	// an unset variable counts as missing
+	switch strings.ToLower(c.Type) {
+	case "basic":
+		username := env(c.Username)
+		if username == "" {
+			return nil, fmt.Errorf("basic auth needs a username")
+		}
+		return &BasicAuth{Username: username, Password: env(c.Password)}, nil
+	case "bearer":
+		token := env(c.Token)
+		if token == "" {
+			return nil, fmt.Errorf("bearer auth needs a token")
+		}
+		return &BearerToken{Token: token}, nil
+	case "oauth2":
+		tokenURL, clientID := env(c.TokenURL), env(c.ClientID)
+		if tokenURL == "" || clientID == "" {
+			return nil, fmt.Errorf("oauth2 auth needs token_url and client_id")
+		}
+		return &OAuth2ClientCredentials{
+			TokenURL:     tokenURL,
+			ClientID:     clientID,
+			ClientSecret: env(c.ClientSecret),
+			Scopes:       c.Scopes,
+		}, nil
+	case "hmac":
+		secret := env(c.Secret)
+		if secret == "" {
+			return nil, fmt.Errorf("hmac auth needs a secret")
+		}
+		return &HMACSigner{KeyID: env(c.KeyID), Secret: []byte(secret), Header: c.Header}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth type %q, use basic, bearer, oauth2 or hmac", c.Type)
+	}
+}
+
+// BasicAuth sends HTTP basic credentials
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (b *BasicAuth) Authenticate(req *http.Request, _ []byte) error {
+	req.SetBasicAuth(b.Username, b.Password)
+	return nil
+}
+
+// BearerToken sends a static bearer token
+type BearerToken struct {
+	Token string
+}
+
+func (b *BearerToken) Authenticate(req *http.Request, _ []byte) error {
+	req.Header.Set("Authorization", "Bearer "+b.Token)
+	return nil
+}
+
+// tokenRefreshMargin renews OAuth2 tokens this long before they expire
+const tokenRefreshMargin = 30 * time.Second
+
+// OAuth2ClientCredentials fetches a token with the client-credentials grant,
+// caches it and fetches a new one shortly before it expires. Only one fetch
+// runs at a time; callers that need a token meanwhile wait for its result.
+type OAuth2ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	fetch   *tokenFetch // the fetch in progress, if any
+	client  *http.Client
+}
+
+// tokenFetch is one token request that concurrent callers share
+type tokenFetch struct {
+	done  chan struct{} // closed once token and err are set
+	token string
+	err   error
+}
+
+func (o *OAuth2ClientCredentials) Authenticate(req *http.Request, _ []byte) error {
+	token, err := o.Token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns the cached token, fetching a new one when it is missing or about to expire
+func (o *OAuth2ClientCredentials) Token() (string, error) {
+	o.mu.Lock()
+	if o.token != "" && time.Now().Add(tokenRefreshMargin).Before(o.expires) {
+		token := o.token
+		o.mu.Unlock()
+		return token, nil
+	}
+	if f := o.fetch; f != nil {
+		o.mu.Unlock()
+		<-f.done
+		return f.token, f.err
+	}
+	f := &tokenFetch{done: make(chan struct{})}
+	o.fetch = f
+	if o.client == nil {
+		o.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	client := o.client
+	o.mu.Unlock()
+
+	// The token endpoint is called without the lock so a slow one doesn't
+	// hold up callers beyond those waiting for this fetch
+	token, expires, err := o.fetchToken(client)
+
+	o.mu.Lock()
+	if err == nil {
+		o.token, o.expires = token, expires
+	}
+	o.fetch = nil
+	o.mu.Unlock()
+	f.token, f.err = token, err
+	close(f.done)
+	return token, err
+}
+
+// fetchToken requests a new token from the token endpoint
+func (o *OAuth2ClientCredentials) fetchToken(client *http.Client) (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	tokenReq, err := http.NewRequest("POST", o.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error creating token request: %w", err)
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+
+	resp, err := client.Do(tokenReq)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error fetching token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("error decoding token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token response has no access_token")
+	}
+
+	expires := time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	if body.ExpiresIn <= 0 {
+		expires = time.Now().Add(time.Hour)
+	}
+	return body.AccessToken, expires, nil
+}
+
+// HMACSigner signs each request with HMAC-SHA256 over
+//
+//	METHOD \n PATH?QUERY \n UNIX-TIMESTAMP \n hex(SHA256(body))
+//
+// and sends the timestamp in X-Signature-Timestamp
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+	Header string
+	now    func() time.Time
+}
+
+func (h *HMACSigner) Authenticate(req *http.Request, body []byte) error {
+	now := time.Now
+	if h.now != nil {
+		now = h.now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, h.Secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", req.Method, req.URL.RequestURI(), timestamp, hex.EncodeToString(bodyHash[:]))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	header := h.Header
+	if header == "" {
+		header = "Authorization"
+	}
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set(header, fmt.Sprintf("HMAC-SHA256 KeyId=%s, Signature=%s", h.KeyID, signature))
+	return nil
+}
@@ -0,0 +1,166 @@
+package generator
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
+)
+
+func TestNewAuthProvider_BasicAndBearer(t *testing.T) {
+	t.Setenv("TEST_API_TOKEN", "s3cret")
+
+	bearer, err := NewAuthProvider(&AuthConfig{Type: "bearer", Token: "${TEST_API_TOKEN}"})
+	assert.NoError(t, err)
+	req, _ := http.NewRequest("GET", "http://example.com/items", nil)
+	assert.NoError(t, bearer.Authenticate(req, nil))
+	assert.Equal(t, "Bearer s3cret", req.Header.Get("Authorization"))
+
+	basic, err := NewAuthProvider(&AuthConfig{Type: "basic", Username: "alice", Password: "pw"})
+	assert.NoError(t, err)
+	req, _ = http.NewRequest("GET", "http://example.com/items", nil)
+	assert.NoError(t, basic.Authenticate(req, nil))
+	user, pass, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "alice", user)
+	assert.Equal(t, "pw", pass)
+
+	_, err = NewAuthProvider(&AuthConfig{Type: "kerberos"})
+	assert.ErrorContains(t, err, "unknown auth type")
+	_, err = NewAuthProvider(&AuthConfig{Type: "bearer"})
+	assert.ErrorContains(t, err, "needs a token")
+
+	// Basic and bearer both check their values after ${NAME} expansion
+	_, err = NewAuthProvider(&AuthConfig{Type: "basic", Username: "${TEST_UNSET_USER}"})
+	assert.ErrorContains(t, err, "needs a username")
+	_, err = NewAuthProvider(&AuthConfig{Type: "bearer", Token: "${TEST_UNSET_TOKEN}"})
+	assert.ErrorContains(t, err, "needs a token")
+}
+
+func TestOAuth2ClientCredentials_CachesAndRefreshes(t *testing.T) {
+	var issued int64
+	expiresIn := 3600
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "client" || secret != "secret" || r.Form.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt64(&issued, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d}`, n, expiresIn)
+	}))
+	defer tokenServer.Close()
+
+	provider, err := NewAuthProvider(&AuthConfig{Type: "oauth2", TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret"})
+	assert.NoError(t, err)
+	oauth := provider.(*OAuth2ClientCredentials)
+
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		assert.NoError(t, provider.Authenticate(req, nil))
+		assert.Equal(t, "Bearer token-1", req.Header.Get("Authorization"))
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&issued))
+
+	// a token about to expire is replaced before use
+	oauth.expires = time.Now().Add(10 * time.Second)
+	token, err := oauth.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "token-2", token)
+}
+
+func TestOAuth2ClientCredentials_ConcurrentCallersShareOneFetch(t *testing.T) {
+	var issued int64
+	release := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		n := atomic.AddInt64(&issued, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600}`, n)
+	}))
+	defer tokenServer.Close()
+
+	oauth := &OAuth2ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client"}
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = oauth.Token()
+		}(i)
+	}
+
+	// The lock is free while the token endpoint is slow
+	time.Sleep(50 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		oauth.mu.Lock()
+		oauth.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("token fetch holds the lock")
+	}
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&issued))
+	assert.Equal(t, []string{"token-1", "token-1", "token-1", "token-1", "token-1"}, tokens)
+}
+
+func TestHMACSigner_SignsMethodPathTimestampAndBody(t *testing.T) {
+	signer := &HMACSigner{KeyID: "k1", Secret: []byte("key"), now: func() time.Time { return time.Unix(1700000000, 0) }}
+	body := []byte(`{"id":1}`)
+	req, _ := http.NewRequest("POST", "http://example.com/items?x=1", nil)
+
+	assert.NoError(t, signer.Authenticate(req, body))
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("POST\n/items?x=1\n1700000000\n" + hex.EncodeToString(bodyHash[:])))
+	expected := "HMAC-SHA256 KeyId=k1, Signature=" + hex.EncodeToString(mac.Sum(nil))
+
+	assert.Equal(t, expected, req.Header.Get("Authorization"))
+	assert.Equal(t, "1700000000", req.Header.Get("X-Signature-Timestamp"))
+}
+
+func TestScenario_HeadersAndAuthReachTheServer(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	s, err := ParseScenario([]byte(`
+headers:
+  X-Team: perf
+  X-Env: staging
+auth:
+  type: bearer
+  token: abc
+endpoints:
+  - url: "{base}/items"
+    headers:
+      X-Env: prod
+`), server.URL)
+	assert.NoError(t, err)
+
+	_, err = NewClient(spec.TransportOptions{}).Do(s.NextRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, "perf", got.Get("X-Team"))
+	assert.Equal(t, "prod", got.Get("X-Env"))
+	assert.Equal(t, "Bearer abc", got.Get("Authorization"))
+}
@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"traffic-generator/spec"
+)
+
+// CaptureRecord is one line of a capture file. It holds everything needed to
+// send the request again, in the NDJSON format replay mode reads.
+type CaptureRecord struct {
+	OffsetMs   float64           `json:"offset_ms"` // when the request was sent, from the start of the run
+	Seq        uint64            `json:"seq"`       // order in which requests were materialized; lines are in the order they were sent
+	Seed       int64             `json:"seed"`      // run seed the request was generated with
+	Endpoint   string            `json:"endpoint"`
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       *string           `json:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty"` // used instead of body when it is not valid UTF-8
+}
+
+// Capture records every request a run sends so it can be replayed byte for byte.
+// It shares the result log's writer, without rotation so one file holds the whole run.
+// The file holds the auth headers as sent, credentials included, in plain text.
+type Capture struct {
+	log  *ResultLog
+	seed int64
+	seq  uint64
+}
+
+// OpenCapture truncates (or creates) the capture file at path
+func OpenCapture(path string, seed int64) (*Capture, error) {
+	log, err := OpenResultLog(spec.ResultLogOptions{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{log: log, seed: seed}, nil
+}
+
+// Write queues the record for a request sent offset into the run, with the
+// headers it holds itself
+func (c *Capture) Write(request *Request, offset time.Duration) {
+	c.write(c.record(request, offset), nil)
+}
+
+// record numbers the request and takes down all of it but the headers auth
+// adds, which are only known once it is sent. Only the first attempt is
+// captured; a replay applies its own retry policy.
+func (c *Capture) record(request *Request, offset time.Duration) *CaptureRecord {
+	rec := &CaptureRecord{
+		OffsetMs: millis(offset),
+		Seq:      atomic.AddUint64(&c.seq, 1),
+		Seed:     c.seed,
+		Endpoint: request.Endpoint,
+		Method:   request.Method,
+		URL:      request.URL,
+		Headers:  request.Headers,
+	}
+	if request.Body != nil {
+		if utf8.Valid(request.Body) {
+			body := string(request.Body)
+			rec.Body = &body
+		} else {
+			rec.BodyBase64 = base64.StdEncoding.EncodeToString(request.Body)
+		}
+	}
+	return rec
+}
+
+// write queues rec with the headers the request was sent with, when it got
+// that far. With auth configured they hold the credentials, so a replay of the
+// capture is authenticated too; a signature or token that has expired by then
+// is sent as recorded.
+func (c *Capture) write(rec *CaptureRecord, sent http.Header) {
+	if sent != nil {
+		rec.Headers = make(map[string]string, len(sent))
+		for k := range sent {
+			rec.Headers[k] = sent.Get(k)
+		}
+	}
+	c.log.records <- *rec
+}
+
+// Close flushes pending records and closes the file
+func (c *Capture) Close() error {
+	return c.log.Close()
+}
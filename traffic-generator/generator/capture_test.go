@@ -0,0 +1,162 @@
+package generator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapture_RecordsReplayableRequests(t *testing.T) {
+	var mu sync.Mutex
+	bodies := map[string][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies[r.Header.Get("X-Run")] = append(bodies[r.Header.Get("X-Run")], r.Method+" "+string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	scenario, err := ParseScenario([]byte(`
+headers: {X-Run: original}
+endpoints:
+  - url: "{base}/items"
+    methods: {POST: 1, GET: 1}
+    payload: random
+`), server.URL)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	capture, err := OpenCapture(path, 42)
+	assert.NoError(t, err)
+	Run(Options{Count: 10, Interval: 5 * time.Millisecond, Capture: capture}, scenario)
+	assert.NoError(t, capture.Close())
+
+	// every line carries the seed and a sequence number
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		var rec CaptureRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		assert.Equal(t, int64(42), rec.Seed)
+		assert.NotZero(t, rec.Seq)
+	}
+	assert.Equal(t, 10, lines)
+
+	rec, err := LoadRecording(path, "")
+	assert.NoError(t, err)
+	for _, r := range rec {
+		r.Headers["X-Run"] = "replay"
+	}
+	Run(Options{Mode: "replay", Replay: rec}, nil)
+
+	assert.ElementsMatch(t, bodies["original"], bodies["replay"])
+}
+
+func TestCapture_RecordsAuthHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	scenario, err := ParseScenario([]byte(`
+auth: {type: bearer, token: s3cret}
+endpoints:
+  - url: "{base}/items"
+`), server.URL)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	capture, err := OpenCapture(path, 1)
+	assert.NoError(t, err)
+	Run(Options{Count: 2, Interval: 5 * time.Millisecond, Capture: capture}, scenario)
+	assert.NoError(t, capture.Close())
+
+	// The replay has no auth of its own and sends the captured credentials
+	rec, err := LoadRecording(path, "")
+	assert.NoError(t, err)
+	Run(Options{Mode: "replay", Replay: rec}, nil)
+
+	assert.Equal(t, []string{"Bearer s3cret", "Bearer s3cret", "Bearer s3cret", "Bearer s3cret"}, auths)
+}
+
+// countingAuth signs every request differently, like a nonce or a timestamp would
+type countingAuth struct{ calls int64 }
+
+func (a *countingAuth) Authenticate(req *http.Request, body []byte) error {
+	req.Header.Set("Authorization", fmt.Sprintf("Nonce %d", atomic.AddInt64(&a.calls, 1)))
+	return nil
+}
+
+func TestCapture_RecordsTheHeadersActuallySent(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent = append(sent, r.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	auth := &countingAuth{}
+	scenario := DefaultScenario(server.URL)
+	scenario.Endpoints[0].auth = auth
+
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	capture, err := OpenCapture(path, 1)
+	assert.NoError(t, err)
+	Run(Options{Count: 5, Interval: 5 * time.Millisecond, Capture: capture}, scenario)
+	assert.NoError(t, capture.Close())
+
+	rec, err := LoadRecording(path, "")
+	assert.NoError(t, err)
+	var captured []string
+	for _, r := range rec {
+		captured = append(captured, r.Headers["Authorization"])
+	}
+	assert.ElementsMatch(t, sent, captured)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&auth.calls), "each request is signed once")
+}
+
+func TestCapture_BinaryBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	capture, err := OpenCapture(path, 1)
+	assert.NoError(t, err)
+
+	body := []byte{0xff, 0x00, 'a', 0xfe}
+	capture.Write(&Request{Endpoint: "bin", Method: "PUT", URL: "http://x/bin", Body: body}, time.Second)
+	assert.NoError(t, capture.Close())
+
+	rec, err := LoadRecording(path, "")
+	assert.NoError(t, err)
+	if assert.Len(t, rec, 1) {
+		assert.Equal(t, body, rec[0].Body)
+		assert.Equal(t, "bin", rec[0].Endpoint)
+	}
+}
+
+func TestSetSeed_ReproducesPayloads(t *testing.T) {
+	SetSeed(7)
+	first := [][]byte{RandomData(), RandomData()}
+	SetSeed(7)
+	second := [][]byte{RandomData(), RandomData()}
+
+	assert.Equal(t, first, second)
+}
@@ -0,0 +1,246 @@
+package generator
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"traffic-generator/spec"
+)
+
+// Control steers a run while it goes: it pauses and resumes it, overrides the
+// target rate and stops it early. Stopping lets requests in flight finish so the
+// run still ends with complete stats and report. Control serves these actions
+// over HTTP, see ServeHTTP.
+type Control struct {
+	mu          sync.Mutex
+	mode        string // of the attached run
+	halt        func() // stops the attached run, nil until Run attaches one
+	stopping    bool
+	paused      bool
+	resume      chan struct{} // closed when a pause ends
+	pausedAt    time.Time
+	pausedTotal time.Duration // of the pauses that ended
+	rate        float64       // requests per second set by the operator, 0 follows the configured rate
+}
+
+// ControlStatus is what the control endpoint reports
+type ControlStatus struct {
+	State  string  `json:"state"`          // "running", "paused" or "stopping"
+	Rate   float64 `json:"rate,omitempty"` // operator rate override in requests per second
+	Paused string  `json:"paused_for"`     // total time spent paused
+}
+
+// NewControl returns a control for the next run passed it in Options
+func NewControl() *Control {
+	return &Control{}
+}
+
+// attach connects the control to the run about to start; a stop that came
+// before the run began halts it at once
+func (c *Control) attach(mode string, halt func()) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mode, c.halt = mode, halt
+	if c.stopping {
+		halt()
+	}
+}
+
+// Pause holds back new requests until Resume; requests in flight finish.
+// Paused time doesn't count toward the run duration or the load profile.
+func (c *Control) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused || c.stopping {
+		return
+	}
+	c.paused, c.pausedAt = true, time.Now()
+	c.resume = make(chan struct{})
+}
+
+// Resume continues a paused run
+func (c *Control) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return
+	}
+	c.paused = false
+	c.pausedTotal += time.Since(c.pausedAt)
+	close(c.resume)
+}
+
+// SetRate replaces the target rate, in requests per second, of an open mode
+// run; 0 returns to the configured rate or profile
+func (c *Control) SetRate(rate float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode != "" && c.mode != "open" {
+		return fmt.Errorf("the rate can only be changed in open mode, not %s mode", c.mode)
+	}
+	if rate < 0 {
+		return fmt.Errorf("rate must not be negative")
+	}
+	c.rate = rate
+	return nil
+}
+
+// Stop ends the run gracefully: no new requests start, requests in flight
+// finish and Run returns its stats as usual. It also ends a pause.
+func (c *Control) Stop() {
+	c.mu.Lock()
+	c.stopping = true
+	halt := c.halt
+	c.mu.Unlock()
+
+	c.Resume()
+	if halt != nil {
+		halt()
+	}
+}
+
+// Status reports the state of the run
+func (c *Control) Status() ControlStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := ControlStatus{State: "running", Rate: c.rate, Paused: c.pausedForLocked().Round(time.Millisecond).String()}
+	switch {
+	case c.stopping:
+		status.State = "stopping"
+	case c.paused:
+		status.State = "paused"
+	}
+	return status
+}
+
+// wait blocks while the run is paused, or until stop is closed, and reports
+// whether it had to
+func (c *Control) wait(stop <-chan struct{}) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	paused, resume := c.paused, c.resume
+	c.mu.Unlock()
+	if !paused {
+		return false
+	}
+	select {
+	case <-resume:
+	case <-stop:
+	}
+	return true
+}
+
+// pausedFor returns the time spent paused so far, the current pause included
+func (c *Control) pausedFor() time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pausedForLocked()
+}
+
+func (c *Control) pausedForLocked() time.Duration {
+	total := c.pausedTotal
+	if c.paused {
+		total += time.Since(c.pausedAt)
+	}
+	return total
+}
+
+// rateAt applies the operator's rate override to a run's rate function
+func (c *Control) rateAt(planned func(time.Duration) float64) func(time.Duration) float64 {
+	if c == nil {
+		return planned
+	}
+	return func(elapsed time.Duration) float64 {
+		c.mu.Lock()
+		rate := c.rate
+		c.mu.Unlock()
+		if rate > 0 {
+			return rate
+		}
+		return planned(elapsed)
+	}
+}
+
+// runClock measures how long a run has been going with its pauses left out,
+// so limits, profiles and replay offsets stand still while it is paused
+type runClock struct {
+	start   time.Time
+	control *Control
+	before  time.Duration // paused before the run started
+}
+
+func newRunClock(control *Control) runClock {
+	return runClock{start: time.Now(), control: control, before: control.pausedFor()}
+}
+
+func (c runClock) elapsed() time.Duration {
+	return time.Since(c.start) - (c.control.pausedFor() - c.before)
+}
+
+// ServeHTTP serves the control actions:
+//
+//	GET  /status              state, rate override and time paused
+//	POST /pause               hold back new requests
+//	POST /resume              continue a paused run
+//	POST /rate?value=50/s     change the target rate, value=plan returns to the configured one
+//	POST /stop                finish requests in flight, write the report and exit
+func (c *Control) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/status" && r.Method == http.MethodGet {
+		writeJSON(rw, http.StatusOK, c.Status())
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(rw, r)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/pause":
+		c.Pause()
+	case "/resume":
+		c.Resume()
+	case "/stop":
+		c.Stop()
+	case "/rate":
+		value := r.FormValue("value")
+		var rate float64
+		if value != "plan" {
+			var err error
+			if rate, err = spec.ParseRatePerSecond(value); err != nil || rate <= 0 {
+				writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "use a rate above zero like '50/s', or 'plan'"})
+				return
+			}
+		}
+		if err := c.SetRate(rate); err != nil {
+			writeJSON(rw, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+	default:
+		http.NotFound(rw, r)
+		return
+	}
+	fmt.Println("Control:", strings.TrimSpace(r.URL.Path[1:]+" "+r.FormValue("value")))
+	writeJSON(rw, http.StatusOK, c.Status())
+}
+
+// ServeControl serves c at addr in the background; errors are printed, not
+// fatal, so a busy port doesn't stop the run
+func ServeControl(addr string, c *Control) {
+	server := &http.Server{Addr: addr, Handler: c, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			fmt.Println("Control server error:", err)
+		}
+	}()
+}
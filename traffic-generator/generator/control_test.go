@@ -0,0 +1,138 @@
+package generator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"traffic-generator/spec"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControl_PauseResumeStop(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+	}))
+	defer server.Close()
+
+	control := NewControl()
+	done := make(chan *Stats)
+	go func() {
+		done <- Run(Options{Interval: 10 * time.Millisecond, Control: control}, DefaultScenario(server.URL))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	control.Pause()
+	time.Sleep(50 * time.Millisecond) // let requests in flight finish
+	paused := atomic.LoadInt64(&hits)
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, paused, atomic.LoadInt64(&hits), "no requests while paused")
+	assert.Equal(t, "paused", control.Status().State)
+
+	control.Resume()
+	time.Sleep(100 * time.Millisecond)
+	assert.Greater(t, atomic.LoadInt64(&hits), paused)
+
+	control.Stop()
+	select {
+	case stats := <-done:
+		assert.Equal(t, uint64(atomic.LoadInt64(&hits)), stats.Overall.Total)
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not stop")
+	}
+}
+
+func TestControl_StopDropsRequestsQueuedBehindTheInFlightCap(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	for _, pause := range []bool{false, true} {
+		atomic.StoreInt64(&hits, 0)
+		control := NewControl()
+		done := make(chan *Stats)
+		go func() {
+			// The pacer queues up to 64 due requests while the only slot is busy
+			done <- Run(Options{Interval: time.Millisecond, MaxInFlight: 1, Overflow: spec.OverflowDelay, Control: control}, DefaultScenario(server.URL))
+		}()
+
+		time.Sleep(150 * time.Millisecond)
+		if pause {
+			control.Pause()
+			time.Sleep(50 * time.Millisecond) // the request in flight finishes
+			held := atomic.LoadInt64(&hits)
+			time.Sleep(250 * time.Millisecond)
+			assert.Equal(t, held, atomic.LoadInt64(&hits), "no queued requests sent while paused")
+		}
+		start := time.Now()
+		control.Stop()
+		select {
+		case stats := <-done:
+			assert.Less(t, time.Since(start), 300*time.Millisecond)
+			assert.Equal(t, uint64(atomic.LoadInt64(&hits)), stats.Overall.Total)
+			assert.LessOrEqual(t, stats.Overall.Total, uint64(5))
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not stop")
+		}
+	}
+}
+
+func TestControl_SetRate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	control := NewControl()
+	assert.NoError(t, control.SetRate(200))
+	stats := Run(Options{Duration: 300 * time.Millisecond, Interval: time.Second, Control: control}, DefaultScenario(server.URL))
+
+	// 1/s as configured would send none in 300ms, the override about 60
+	assert.Greater(t, stats.Overall.Total, uint64(30))
+
+	closed := NewControl()
+	closed.attach("closed", func() {})
+	assert.Error(t, closed.SetRate(10))
+}
+
+func TestControl_StopBeforeRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	control := NewControl()
+	control.Stop()
+	stats := Run(Options{Mode: "closed", VirtualUsers: 2, Control: control}, DefaultScenario(server.URL))
+
+	assert.Zero(t, stats.Overall.Total)
+}
+
+func TestControl_ServeHTTP(t *testing.T) {
+	control := NewControl()
+	server := httptest.NewServer(control)
+	defer server.Close()
+
+	post := func(path string) int {
+		resp, err := http.Post(server.URL+path, "", nil)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post("/pause"))
+	assert.Equal(t, "paused", control.Status().State)
+	assert.Equal(t, http.StatusOK, post("/rate?value=50/s"))
+	assert.Equal(t, 50.0, control.Status().Rate)
+	assert.Equal(t, http.StatusBadRequest, post("/rate?value=fast"))
+	assert.Equal(t, http.StatusOK, post("/rate?value=plan"))
+	assert.Zero(t, control.Status().Rate)
+	assert.Equal(t, http.StatusOK, post("/resume"))
+	assert.Equal(t, "running", control.Status().State)
+	assert.Equal(t, http.StatusOK, post("/stop"))
+	assert.Equal(t, "stopping", control.Status().State)
+	assert.Equal(t, http.StatusNotFound, post("/explode"))
+}
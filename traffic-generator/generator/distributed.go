@@ -0,0 +1,458 @@
+package generator
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"traffic-generator/spec"
+)
+
+// WorkerRun is the share of a distributed run one worker sends. Transport,
+// retry, result log, payload size and in-flight cap settings come from the
+// worker's own config (see NewWorker and Worker.PayloadSize).
+type WorkerRun struct {
+	StartAt      time.Time            `json:"start_at"` // all workers start sending at this moment
+	Seed         int64                `json:"seed"`
+	Scenario     string               `json:"scenario,omitempty"` // scenario YAML, the default scenario for BaseURL when empty
+	Env          map[string]string    `json:"env,omitempty"`      // values of the ${NAME} references in the scenario's auth, see ScenarioEnv
+	BaseURL      string               `json:"base_url"`
+	Mode         string               `json:"mode"`
+	Count        int                  `json:"count"`
+	Duration     time.Duration        `json:"duration"`
+	Interval     time.Duration        `json:"interval"`
+	Profile      *spec.Profile        `json:"profile,omitempty"`
+	Arrival      *spec.ArrivalOptions `json:"arrival,omitempty"` // the seed is derived from Seed
+	VirtualUsers int                  `json:"virtual_users"`
+	ThinkTime    time.Duration        `json:"think_time"`
+}
+
+// SplitRun divides run evenly between n workers: counts and virtual users are
+// shared out, rates and profiles scaled down, and each worker gets its own seed
+func SplitRun(run WorkerRun, n int) ([]WorkerRun, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("no workers")
+	}
+	if run.Count > 0 && run.Count < n {
+		return nil, fmt.Errorf("NO_OF_API %d is less than the %d workers", run.Count, n)
+	}
+	if run.Mode == "closed" && run.VirtualUsers < n {
+		return nil, fmt.Errorf("VIRTUAL_USERS %d is less than the %d workers", run.VirtualUsers, n)
+	}
+
+	shares := make([]WorkerRun, n)
+	for i := range shares {
+		share := run
+		share.Seed = spec.DeriveSeed(run.Seed, fmt.Sprintf("worker-%d", i))
+		share.Count = splitCount(run.Count, n, i)
+		share.VirtualUsers = splitCount(run.VirtualUsers, n, i)
+		share.Interval = run.Interval * time.Duration(n)
+		if run.Profile != nil {
+			share.Profile = run.Profile.Scale(1 / float64(n))
+		}
+		shares[i] = share
+	}
+	return shares, nil
+}
+
+// splitCount returns worker i's part of total, the first total%n workers taking one extra
+func splitCount(total, n, i int) int {
+	count := total / n
+	if i < total%n {
+		count++
+	}
+	return count
+}
+
+// options turns the run into engine options on top of the worker's base options
+func (run WorkerRun) options(base Options) (Options, *Scenario, error) {
+	scenario := DefaultScenario(run.BaseURL)
+	if run.Scenario != "" {
+		var err error
+		// A scenario sent over the network never reads the worker's environment
+		scenario, err = ParseScenarioEnv([]byte(run.Scenario), run.BaseURL, run.Env)
+		if err != nil {
+			return base, nil, err
+		}
+		if err := scenario.CheckMode(run.Mode); err != nil {
+			return base, nil, err
+		}
+	}
+
+	opts := base
+	opts.Mode = run.Mode
+	opts.Count = run.Count
+	opts.Duration = run.Duration
+	opts.Interval = run.Interval
+	opts.Profile = run.Profile
+	opts.VirtualUsers = run.VirtualUsers
+	opts.ThinkTime = run.ThinkTime
+	opts.Arrival = nil
+	if run.Arrival != nil {
+		arrival := *run.Arrival
+		arrival.Seed = spec.DeriveSeed(run.Seed, "arrival")
+		var err error
+		opts.Arrival, err = spec.NewArrival(arrival)
+		if err != nil {
+			return base, nil, err
+		}
+	}
+	if opts.Mode != "closed" && opts.Profile == nil && opts.Interval <= 0 {
+		return base, nil, fmt.Errorf("run has no rate")
+	}
+	return opts, scenario, nil
+}
+
+// ScenarioEnv returns the environment variables the auth settings of a
+// scenario reference, with their values here. The coordinator sends them in
+// WorkerRun.Env, so secrets are resolved where the scenario comes from.
+func ScenarioEnv(data []byte) (map[string]string, error) {
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("unable to parse scenario file: %w", err)
+	}
+	env := map[string]string{}
+	for _, c := range s.authConfigs() {
+		for _, v := range c.expandable() {
+			os.Expand(v, func(name string) string {
+				if value, ok := os.LookupEnv(name); ok {
+					env[name] = value
+				}
+				return ""
+			})
+		}
+	}
+	return env, nil
+}
+
+// Worker runs the shares a coordinator sends it, one at a time. It serves
+//
+//	GET  /health   the worker state
+//	POST /run      start a WorkerRun, 409 while another run is going
+//	POST /stop     stop the run going; it still reports its result
+//	GET  /result   202 while running, then the run's Stats as JSON
+//
+// With Token set every request but /health must carry it as a bearer token.
+type Worker struct {
+	PayloadSize spec.SizeDistribution // applied to each run's scenario, as SetPayloadSize does
+	Token       string                // shared with the coordinator
+
+	base Options
+
+	mu        sync.Mutex
+	state     string // "idle", "running" or "done"
+	stats     *Stats
+	control   *Control      // of the latest run
+	collected chan struct{} // closed once the latest run's result is fetched, nil when there is none to wait for
+	closed    bool          // after Stop no new runs start
+}
+
+// NewWorker returns a worker whose runs use the client, retry policy and
+// result log from base
+func NewWorker(base Options) *Worker {
+	return &Worker{base: base, state: "idle"}
+}
+
+func (w *Worker) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if w.Token != "" && r.URL.Path != "/health" && !hasToken(r, w.Token) {
+		writeJSON(rw, http.StatusUnauthorized, map[string]string{"error": "missing or wrong worker token"})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/health":
+		w.mu.Lock()
+		state := w.state
+		w.mu.Unlock()
+		writeJSON(rw, http.StatusOK, map[string]string{"state": state})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/run":
+		var run WorkerRun
+		if err := json.NewDecoder(r.Body).Decode(&run); err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := w.start(run); err != nil {
+			status := http.StatusBadRequest
+			switch err {
+			case errWorkerBusy:
+				status = http.StatusConflict
+			case errWorkerStopped:
+				status = http.StatusServiceUnavailable
+			}
+			writeJSON(rw, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(rw, http.StatusAccepted, map[string]string{"state": "running"})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/stop":
+		w.mu.Lock()
+		if w.control != nil {
+			w.control.Stop()
+		}
+		state := w.state
+		w.mu.Unlock()
+		writeJSON(rw, http.StatusOK, map[string]string{"state": state})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/result":
+		w.mu.Lock()
+		state, stats := w.state, w.stats
+		if state == "done" && w.collected != nil {
+			close(w.collected)
+			w.collected = nil
+		}
+		w.mu.Unlock()
+		switch state {
+		case "done":
+			writeJSON(rw, http.StatusOK, stats)
+		case "running":
+			writeJSON(rw, http.StatusAccepted, map[string]string{"state": state})
+		default:
+			writeJSON(rw, http.StatusNotFound, map[string]string{"error": "no run"})
+		}
+
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+var (
+	errWorkerBusy    = fmt.Errorf("worker is already running")
+	errWorkerStopped = fmt.Errorf("worker is shutting down")
+)
+
+// start checks the run and sends it in the background from run.StartAt
+func (w *Worker) start(run WorkerRun) error {
+	opts, scenario, err := run.options(w.base)
+	if err != nil {
+		return err
+	}
+	if w.PayloadSize != nil {
+		scenario.SetPayloadSize(w.PayloadSize)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errWorkerStopped
+	}
+	if w.state == "running" {
+		return errWorkerBusy
+	}
+	w.state, w.stats = "running", nil
+	w.control, w.collected = NewControl(), make(chan struct{})
+	opts.Control = w.control
+
+	go func() {
+		time.Sleep(time.Until(run.StartAt))
+		fmt.Printf("Starting run share: seed %d\n", run.Seed)
+		SetSeed(run.Seed)
+		stats := Run(opts, scenario)
+
+		w.mu.Lock()
+		w.state, w.stats = "done", stats
+		w.mu.Unlock()
+	}()
+	return nil
+}
+
+// Stop ends the run going, as POST /stop does, and refuses new runs. Wait
+// then tells when the coordinator has the stopped run's result.
+func (w *Worker) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.control != nil {
+		w.control.Stop()
+	}
+}
+
+// Wait waits up to timeout for the result of the latest run to be fetched; it
+// returns at once when there is no result left to fetch
+func (w *Worker) Wait(timeout time.Duration) {
+	w.mu.Lock()
+	collected := w.collected
+	w.mu.Unlock()
+	if collected == nil {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-collected:
+	case <-timer.C:
+	}
+}
+
+// hasToken reports whether r carries token as its bearer token
+func hasToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+// Coordinator splits a run between workers, starts them together and merges
+// their results into one Stats
+type Coordinator struct {
+	Workers    []string      // worker base URLs, e.g. http://worker-1:7070
+	Token      string        // sent to the workers as a bearer token
+	Control    *Control      // optional; stopping it stops every worker, whose results are still merged
+	Client     *http.Client  // control requests, a 10s timeout client when nil
+	StartDelay time.Duration // time for every worker to receive its share, default 2s
+	Poll       time.Duration // how often to ask for results, default 1s
+}
+
+// Run sends each worker its share and waits for all of them to finish. If a
+// worker fails the others are stopped, and the results merged so far are
+// returned with the error
+func (c *Coordinator) Run(run WorkerRun) (*Stats, error) {
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	startDelay, poll := c.StartDelay, c.Poll
+	if startDelay <= 0 {
+		startDelay = 2 * time.Second
+	}
+	if poll <= 0 {
+		poll = time.Second
+	}
+
+	for _, worker := range c.Workers {
+		if err := c.call(client, "GET", worker, "/health", nil, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	run.StartAt = time.Now().Add(startDelay)
+	shares, err := SplitRun(run, len(c.Workers))
+	if err != nil {
+		return nil, err
+	}
+	// Workers that already started are told to stop if a later one fails, and
+	// whatever they measured is still returned alongside the error
+	var failed error
+	stopAll := func(workers []string) {
+		for _, worker := range workers {
+			if err := c.call(client, "POST", worker, "/stop", nil, nil); err != nil {
+				fmt.Println("Error stopping worker:", err)
+			}
+		}
+	}
+
+	var pending []string
+	for i, worker := range c.Workers {
+		if err := c.call(client, "POST", worker, "/run", shares[i], nil); err != nil {
+			failed = err
+			break
+		}
+		pending = append(pending, worker)
+	}
+	if failed != nil {
+		stopAll(pending)
+	} else {
+		fmt.Printf("Started %d workers\n", len(c.Workers))
+	}
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	c.Control.attach(run.Mode, func() { stopOnce.Do(func() { close(stop) }) })
+
+	merged := NewStats()
+	merged.Start = run.StartAt
+	for len(pending) > 0 {
+		wait := time.NewTimer(poll)
+		select {
+		case <-wait.C:
+		case <-stop:
+			wait.Stop()
+			stop = nil // workers are told once, then polled as usual
+			stopAll(pending)
+			continue
+		}
+
+		var running []string
+		healthy := failed == nil
+		for _, worker := range pending {
+			stats := &Stats{}
+			err := c.call(client, "GET", worker, "/result", nil, stats)
+			if err == errStillRunning {
+				running = append(running, worker)
+				continue
+			}
+			if err != nil {
+				fmt.Println("Error collecting worker result:", err)
+				if failed == nil {
+					failed = err
+				}
+				continue
+			}
+			merged.Merge(stats)
+			fmt.Printf("Worker %s finished\n", worker)
+		}
+		pending = running
+		if healthy && failed != nil {
+			stop = nil
+			stopAll(pending)
+		}
+	}
+	return merged, failed
+}
+
+var errStillRunning = fmt.Errorf("worker still running")
+
+// call sends a control request; out, when set, receives the decoded response
+func (c *Coordinator) call(client *http.Client, method, worker, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(worker, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("worker %s: %w", worker, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("worker %s: %w", worker, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusAccepted && method == "GET":
+		return errStillRunning
+	case resp.StatusCode >= 300:
+		var msg struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&msg)
+		return fmt.Errorf("worker %s: %s returned %d %s", worker, path, resp.StatusCode, msg.Error)
+	case out != nil:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("worker %s: %w", worker, err)
+		}
+	}
+	return nil
+}
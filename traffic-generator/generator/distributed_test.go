@@ -0,0 +1,287 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
+)
+
+func TestSplitRun(t *testing.T) {
+	profile, err := spec.ParseProfile("ramp 90/s 1s, hold 1s", 30)
+	assert.NoError(t, err)
+
+	shares, err := SplitRun(WorkerRun{Seed: 5, Count: 10, Interval: 10 * time.Millisecond, Profile: profile}, 3)
+
+	assert.NoError(t, err)
+	if assert.Len(t, shares, 3) {
+		assert.Equal(t, []int{4, 3, 3}, []int{shares[0].Count, shares[1].Count, shares[2].Count})
+		assert.Equal(t, 30*time.Millisecond, shares[0].Interval)
+		assert.InDelta(t, 10, shares[0].Profile.RateAt(0), 1e-9)
+		assert.InDelta(t, 30, shares[0].Profile.RateAt(1500*time.Millisecond), 1e-9)
+		assert.NotEqual(t, shares[0].Seed, shares[1].Seed)
+	}
+	// the original profile is untouched
+	assert.InDelta(t, 90, profile.RateAt(1500*time.Millisecond), 1e-9)
+
+	_, err = SplitRun(WorkerRun{Count: 2}, 3)
+	assert.Error(t, err)
+	_, err = SplitRun(WorkerRun{Mode: "closed", VirtualUsers: 1}, 2)
+	assert.Error(t, err)
+}
+
+func TestStats_JSONRoundTripAndMerge(t *testing.T) {
+	a := NewStats()
+	a.Record(&Result{Endpoint: "e", Method: "GET", StatusCode: 200, Latency: 10 * time.Millisecond, Attempt: 1}, nil)
+	a.Record(&Result{Endpoint: "e", Method: "GET", StatusCode: 503, Latency: 5 * time.Millisecond, Attempt: 2}, nil)
+	a.Finish()
+	// JSON keeps wall clock times only
+	a.Start, a.End = a.Start.UTC().Round(0), a.End.UTC().Round(0)
+
+	data, err := json.Marshal(a)
+	assert.NoError(t, err)
+	decoded := &Stats{}
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, a.Summary(), decoded.Summary())
+
+	b := NewStats()
+	b.Start = a.Start
+	b.Record(&Result{Endpoint: "e", Method: "GET", StatusCode: 500, Latency: 30 * time.Millisecond, Attempt: 1}, nil)
+	b.Record(&Result{Endpoint: "f", Method: "POST", Attempt: 1}, errStillRunning)
+	b.Finish()
+
+	merged := NewStats()
+	merged.Start = a.Start
+	merged.Merge(decoded)
+	merged.Merge(b)
+
+	assert.Equal(t, uint64(2), merged.Overall.Total)
+	assert.Equal(t, uint64(2), merged.Latencies[StatKey{"e", "GET"}].Total)
+	assert.Equal(t, map[int]int64{200: 1, 500: 1}, merged.Statuses)
+	assert.Equal(t, int64(1), merged.Errors)
+	assert.Equal(t, int64(1), merged.Retries)
+	assert.Equal(t, int64(4), merged.Completed[0])
+	assert.Equal(t, b.End, merged.End)
+}
+
+func TestCoordinator_RunsWorkersTogether(t *testing.T) {
+	var received int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&received, 1)
+	}))
+	defer target.Close()
+
+	var urls []string
+	for i := 0; i < 3; i++ {
+		worker := httptest.NewServer(NewWorker(Options{}))
+		defer worker.Close()
+		urls = append(urls, worker.URL)
+	}
+
+	c := &Coordinator{Workers: urls, StartDelay: 100 * time.Millisecond, Poll: 20 * time.Millisecond}
+	stats, err := c.Run(WorkerRun{Seed: 1, BaseURL: target.URL, Count: 31, Interval: time.Millisecond})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(31), atomic.LoadInt64(&received))
+	assert.Equal(t, uint64(31), stats.Overall.Total)
+	assert.Equal(t, int64(31), stats.Methods["GET"]+stats.Methods["POST"]+stats.Methods["PUT"]+stats.Methods["DELETE"])
+}
+
+func TestWorker_AppliesItsPayloadSize(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		sizes = append(sizes, len(body))
+		mu.Unlock()
+	}))
+	defer target.Close()
+
+	size, err := spec.ParseSizeDistribution("2KB")
+	assert.NoError(t, err)
+	w := NewWorker(Options{})
+	w.PayloadSize = size
+	worker := httptest.NewServer(w)
+	defer worker.Close()
+
+	c := &Coordinator{Workers: []string{worker.URL}, StartDelay: 50 * time.Millisecond, Poll: 20 * time.Millisecond}
+	_, err = c.Run(WorkerRun{
+		BaseURL:  target.URL,
+		Scenario: "endpoints:\n  - url: \"{base}/items\"\n    methods: {POST: 1}\n",
+		Count:    3,
+		Interval: time.Millisecond,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2048, 2048, 2048}, sizes)
+}
+
+func TestCoordinator_StopEndsWorkersAndMergesTheirResults(t *testing.T) {
+	var received int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&received, 1)
+	}))
+	defer target.Close()
+
+	var urls []string
+	for i := 0; i < 2; i++ {
+		worker := httptest.NewServer(NewWorker(Options{}))
+		defer worker.Close()
+		urls = append(urls, worker.URL)
+	}
+
+	control := NewControl()
+	c := &Coordinator{Workers: urls, Control: control, StartDelay: 50 * time.Millisecond, Poll: 20 * time.Millisecond}
+	time.AfterFunc(300*time.Millisecond, control.Stop)
+	start := time.Now()
+	stats, err := c.Run(WorkerRun{Seed: 1, BaseURL: target.URL, Duration: time.Minute, Interval: 10 * time.Millisecond})
+
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Positive(t, stats.Overall.Total)
+	assert.Equal(t, atomic.LoadInt64(&received), int64(stats.Overall.Total))
+}
+
+func TestCoordinator_FailedWorkerStopsTheOthersAndKeepsTheirResults(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	started := httptest.NewServer(NewWorker(Options{}))
+	defer started.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.Error(w, `{"error":"broken"}`, http.StatusInternalServerError)
+		}
+	}))
+	defer broken.Close()
+
+	c := &Coordinator{Workers: []string{started.URL, broken.URL}, StartDelay: 10 * time.Millisecond, Poll: 20 * time.Millisecond}
+	start := time.Now()
+	stats, err := c.Run(WorkerRun{Seed: 1, BaseURL: target.URL, Duration: time.Minute, Interval: 10 * time.Millisecond})
+
+	assert.ErrorContains(t, err, "/run returned 500")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.NotNil(t, stats)
+}
+
+func TestWorker_StopWaitsForTheResultToBeFetched(t *testing.T) {
+	w := NewWorker(Options{})
+	worker := httptest.NewServer(w)
+	defer worker.Close()
+
+	// Nothing to wait for before a run
+	w.Wait(time.Hour)
+
+	assert.NoError(t, w.start(WorkerRun{BaseURL: "http://127.0.0.1:1", Duration: time.Minute, Interval: 10 * time.Millisecond}))
+	w.Stop()
+	assert.ErrorIs(t, w.start(WorkerRun{BaseURL: "http://x", Interval: time.Second, Count: 1}), errWorkerStopped)
+
+	waited := make(chan struct{})
+	go func() {
+		w.Wait(time.Hour)
+		close(waited)
+	}()
+	for {
+		resp, err := http.Get(worker.URL + "/result")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return once the result was fetched")
+	}
+}
+
+func TestWorker_RequiresItsToken(t *testing.T) {
+	w := NewWorker(Options{})
+	w.Token = "s3cret"
+	worker := httptest.NewServer(w)
+	defer worker.Close()
+
+	resp, err := http.Post(worker.URL+"/run", "application/json", bytes.NewReader([]byte(`{}`)))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// The coordinator sends the token; a wrong one is refused
+	wrong := &Coordinator{Workers: []string{worker.URL}, Token: "guess"}
+	_, err = wrong.Run(WorkerRun{BaseURL: "http://x", Count: 1, Interval: time.Second})
+	assert.ErrorContains(t, err, "401")
+	resp, err = http.Get(worker.URL + "/health")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestWorker_ExpandsAuthFromTheRunNotItsEnvironment(t *testing.T) {
+	var got []string
+	var mu sync.Mutex
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	defer target.Close()
+
+	scenario := "auth: {type: bearer, token: \"${TEST_RUN_TOKEN}\"}\nendpoints:\n  - url: \"{base}/items\"\n"
+	t.Setenv("TEST_RUN_TOKEN", "coordinator-secret")
+	env, err := ScenarioEnv([]byte(scenario))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"TEST_RUN_TOKEN": "coordinator-secret"}, env)
+
+	// The worker's own value of the variable is never used
+	t.Setenv("TEST_RUN_TOKEN", "worker-secret")
+	worker := httptest.NewServer(NewWorker(Options{}))
+	defer worker.Close()
+	c := &Coordinator{Workers: []string{worker.URL}, StartDelay: 50 * time.Millisecond, Poll: 20 * time.Millisecond}
+	_, err = c.Run(WorkerRun{BaseURL: target.URL, Scenario: scenario, Env: env, Count: 2, Interval: time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bearer coordinator-secret", "Bearer coordinator-secret"}, got)
+
+	// Without the value from the coordinator the scenario is refused
+	_, err = c.Run(WorkerRun{BaseURL: target.URL, Scenario: scenario, Count: 2, Interval: time.Millisecond})
+	assert.ErrorContains(t, err, "needs a token")
+}
+
+func TestWorker_RejectsSecondRunAndBadRuns(t *testing.T) {
+	worker := httptest.NewServer(NewWorker(Options{}))
+	defer worker.Close()
+
+	post := func(run WorkerRun) int {
+		data, _ := json.Marshal(run)
+		resp, err := http.Post(worker.URL+"/run", "application/json", bytes.NewReader(data))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	resp, err := http.Get(worker.URL + "/result")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	assert.Equal(t, http.StatusBadRequest, post(WorkerRun{BaseURL: "http://x"}))
+	assert.Equal(t, http.StatusBadRequest, post(WorkerRun{Scenario: "endpoints: []", Interval: time.Second}))
+
+	later := WorkerRun{StartAt: time.Now().Add(time.Hour), BaseURL: "http://x", Interval: time.Second, Count: 1}
+	assert.Equal(t, http.StatusAccepted, post(later))
+	assert.Equal(t, http.StatusConflict, post(later))
+
+	resp, err = http.Get(worker.URL + "/result")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+}
@@ -0,0 +1,347 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"traffic-generator/spec"
+)
+
+// Flow is a scripted sequence of requests a virtual user sends in order, such
+// as create, read, update and delete of one item. Steps can extract values from
+// their response into the user's variables, and later steps use them as {name}
+// in their URL, headers and body:
+//
+//	flows:
+//	  - name: item-lifecycle
+//	    steps:
+//	      - name: create
+//	        method: POST
+//	        url: "{base}/items"
+//	        payload: template
+//	        template: {name: {type: name}}
+//	        extract:
+//	          id: $.id
+//	          etag: header ETag
+//	      - method: GET
+//	        url: "{base}/items/{id}"
+//	      - method: DELETE
+//	        url: "{base}/items/{id}"
+//	        headers: {If-Match: "{etag}"}
+//
+// A flow stops at the first step that fails, gets a status of 400 or more or
+// misses a value it should extract.
+type Flow struct {
+	Name   string  `yaml:"name"`
+	Weight int     `yaml:"weight"`
+	Steps  []*Step `yaml:"steps"`
+}
+
+// Step is one request of a flow. It takes the endpoint settings, with a single
+// method instead of a method mix.
+type Step struct {
+	Endpoint `yaml:",inline"`
+	Method   string            `yaml:"method"`
+	Extract  map[string]string `yaml:"extract"` // variable name to "$.json.path" or "header Name"
+
+	extractors []extractor
+}
+
+// extractor reads one variable from a response
+type extractor struct {
+	name   string
+	header string        // response header to read, or
+	path   []pathSegment // JSONPath into the response body
+}
+
+// pathSegment is one step of a JSONPath: a field name or an array index
+type pathSegment struct {
+	field string
+	index int // used when field is empty
+}
+
+// prepare checks the flow and its steps and fills in the scenario defaults
+func (f *Flow) prepare(i int, baseURL string, defaultHeaders map[string]string, defaultAuth AuthProvider, defaultSize spec.SizeDistribution) error {
+	if f.Name == "" {
+		f.Name = fmt.Sprintf("flow-%d", i+1)
+	}
+	if f.Weight < 0 {
+		return fmt.Errorf("flow %q: weight must not be negative", f.Name)
+	}
+	if f.Weight == 0 {
+		f.Weight = 1
+	}
+	if len(f.Steps) == 0 {
+		return fmt.Errorf("flow %q has no steps", f.Name)
+	}
+
+	for j, step := range f.Steps {
+		if step.Name == "" {
+			step.Name = strconv.Itoa(j + 1)
+		}
+		// Steps are reported as endpoints named after the flow and the step
+		step.Name = f.Name + "/" + step.Name
+
+		method := strings.ToUpper(step.Method)
+		if method == "" {
+			method = "GET"
+		}
+		step.Methods = map[string]int{method: 1}
+		if err := prepareEndpoint(&step.Endpoint, baseURL, defaultHeaders, defaultAuth, defaultSize); err != nil {
+			return err
+		}
+		step.Method = method
+
+		names := make([]string, 0, len(step.Extract))
+		for name := range step.Extract {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ex, err := parseExtractor(name, step.Extract[name])
+			if err != nil {
+				return fmt.Errorf("step %q: %w", step.Name, err)
+			}
+			step.extractors = append(step.extractors, ex)
+		}
+	}
+	return nil
+}
+
+// variableName is what {name} placeholders and extracted variables may be called
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pathPattern matches one JSONPath segment: .name, ['name'] or [index]
+var pathPattern = regexp.MustCompile(`^(?:\.([A-Za-z_][A-Za-z0-9_-]*)|\['([^']*)'\]|\[(\d+)\])`)
+
+func parseExtractor(name, spec string) (extractor, error) {
+	ex := extractor{name: name}
+	if !variableName.MatchString(name) {
+		return ex, fmt.Errorf("invalid variable name %q", name)
+	}
+	if name == "base" || name == "rand_int" {
+		return ex, fmt.Errorf("variable name %q is reserved", name)
+	}
+
+	spec = strings.TrimSpace(spec)
+	if header, ok := strings.CutPrefix(spec, "header "); ok {
+		ex.header = strings.TrimSpace(header)
+		if ex.header == "" {
+			return ex, fmt.Errorf("extract %s: header name not set", name)
+		}
+		return ex, nil
+	}
+
+	rest, ok := strings.CutPrefix(spec, "$")
+	if !ok {
+		return ex, fmt.Errorf("extract %s: use a JSONPath like '$.data.id' or 'header Location'", name)
+	}
+	for rest != "" {
+		m := pathPattern.FindStringSubmatch(rest)
+		if m == nil {
+			return ex, fmt.Errorf("extract %s: unsupported JSONPath %q", name, spec)
+		}
+		switch {
+		case m[1] != "":
+			ex.path = append(ex.path, pathSegment{field: m[1]})
+		case m[3] == "":
+			ex.path = append(ex.path, pathSegment{field: m[2]})
+		default:
+			index, _ := strconv.Atoi(m[3])
+			ex.path = append(ex.path, pathSegment{index: index})
+		}
+		rest = rest[len(m[0]):]
+	}
+	return ex, nil
+}
+
+// extract reads the step's variables from a response into vars
+func (s *Step) extract(result *Result, vars map[string]string) error {
+	var doc interface{}
+	parsed := false
+	for _, ex := range s.extractors {
+		if ex.header != "" {
+			value := result.Header.Get(ex.header)
+			if value == "" {
+				return fmt.Errorf("extract %s: no %s header in the response", ex.name, ex.header)
+			}
+			vars[ex.name] = value
+			continue
+		}
+
+		if !parsed {
+			decoder := json.NewDecoder(bytes.NewReader(result.Body))
+			decoder.UseNumber() // ids keep their exact digits
+			if err := decoder.Decode(&doc); err != nil {
+				return fmt.Errorf("extract %s: response is not JSON: %w", ex.name, err)
+			}
+			parsed = true
+		}
+		value, ok := lookupPath(doc, ex.path)
+		if !ok {
+			return fmt.Errorf("extract %s: not found in the response", ex.name)
+		}
+		vars[ex.name] = value
+	}
+	return nil
+}
+
+// lookupPath follows path into a decoded JSON document and returns the value
+// there as text; objects and arrays come back as JSON
+func lookupPath(doc interface{}, path []pathSegment) (string, bool) {
+	for _, seg := range path {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			if seg.field == "" {
+				return "", false
+			}
+			var ok bool
+			if doc, ok = v[seg.field]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			if seg.field != "" || seg.index >= len(v) {
+				return "", false
+			}
+			doc = v[seg.index]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := doc.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		data, _ := json.Marshal(v)
+		return string(data), true
+	}
+}
+
+// placeholder matches {name} in step URLs, headers and bodies
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expand replaces {name} with the user's variables; unknown names are left as they are
+func expand(text string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+	return placeholder.ReplaceAllStringFunc(text, func(m string) string {
+		if value, ok := vars[m[1:len(m)-1]]; ok {
+			return value
+		}
+		return m
+	})
+}
+
+// expandURL is expand for URLs: a value is escaped for the part it lands in,
+// the path before any '?' or the query after it, so it stays one segment or
+// one parameter value whatever characters it holds
+func expandURL(rawURL string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(rawURL, "{") {
+		return rawURL
+	}
+	query := strings.IndexByte(rawURL, '?')
+	var out strings.Builder
+	last := 0
+	for _, m := range placeholder.FindAllStringIndex(rawURL, -1) {
+		out.WriteString(rawURL[last:m[0]])
+		last = m[1]
+
+		value, ok := vars[rawURL[m[0]+1:m[1]-1]]
+		switch {
+		case !ok:
+			out.WriteString(rawURL[m[0]:m[1]])
+		case query >= 0 && m[0] > query:
+			out.WriteString(url.QueryEscape(value))
+		default:
+			out.WriteString(url.PathEscape(value))
+		}
+	}
+	out.WriteString(rawURL[last:])
+	return out.String()
+}
+
+// expandJSON is expand for JSON bodies: a value that lands inside a string is
+// escaped, so quotes or backslashes in it can't break the document, and one
+// outside a string, like a number or an extracted object, goes in as it is
+func expandJSON(body []byte, vars map[string]string) []byte {
+	if len(vars) == 0 || !bytes.Contains(body, []byte("{")) {
+		return body
+	}
+	var out bytes.Buffer
+	inString, escaped := false, false
+	last := 0
+	for _, m := range placeholder.FindAllIndex(body, -1) {
+		// Placeholders hold no quotes, so the text between them decides whether one is in a string
+		for _, c := range body[last:m[0]] {
+			switch {
+			case escaped:
+				escaped = false
+			case inString && c == '\\':
+				escaped = true
+			case c == '"':
+				inString = !inString
+			}
+		}
+		out.Write(body[last:m[0]])
+		last = m[1]
+
+		value, ok := vars[string(body[m[0]+1:m[1]-1])]
+		switch {
+		case !ok:
+			out.Write(body[m[0]:m[1]])
+		case inString:
+			quoted, _ := json.Marshal(value)
+			out.Write(quoted[1 : len(quoted)-1])
+		default:
+			out.WriteString(value)
+		}
+	}
+	out.Write(body[last:])
+	return out.Bytes()
+}
+
+// BuildRequest materializes the step's request with the user's variables
+// filled in. The body is padded after that, so it ends at the drawn size.
+func (s *Step) BuildRequest(vars map[string]string) *Request {
+	req := s.Endpoint.build(s.Method)
+	req.URL = expandURL(req.URL, vars)
+	for k, v := range req.Headers {
+		req.Headers[k] = expand(v, vars)
+	}
+	if req.Body != nil {
+		req.Body = expandJSON(req.Body, vars)
+	}
+	req.KeepResponse = len(s.extractors) > 0
+	return s.Endpoint.pad(req)
+}
+
+// CheckMode reports an error when the scenario's flows can't run in mode: a
+// flow follows one user from step to step, which only closed mode has
+func (s *Scenario) CheckMode(mode string) error {
+	if len(s.Flows) > 0 && mode != "closed" {
+		return fmt.Errorf("flows need closed mode (virtual users), not %s mode", mode)
+	}
+	return nil
+}
+
+// PickFlow chooses a flow using the configured flow weights
+func (s *Scenario) PickFlow() *Flow {
+	if len(s.Flows) == 1 {
+		return s.Flows[0]
+	}
+	return s.Flows[pickWeighted(s.flowWeights, s.totalFlowWeight)]
+}
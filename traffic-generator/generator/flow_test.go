@@ -0,0 +1,235 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const itemFlow = `
+flows:
+  - name: item
+    steps:
+      - name: create
+        method: POST
+        url: "{base}/items"
+        payload: static
+        body: '{"name": "widget"}'
+        extract:
+          id: $.data.items[0]['id']
+          etag: header ETag
+      - name: read
+        url: "{base}/items/{id}"
+      - name: update
+        method: PUT
+        url: "{base}/items/{id}"
+        headers: {If-Match: "{etag}"}
+        payload: static
+        body: '{"id": {id}, "name": "gadget"}'
+      - name: delete
+        method: DELETE
+        url: "{base}/items/{id}"
+`
+
+// itemServer serves a small item store; ids count up from 41
+func itemServer(seen *[]string) *httptest.Server {
+	var mu sync.Mutex
+	next := 40
+	items := make(map[string]bool)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		*seen = append(*seen, r.Method+" "+r.URL.Path+" "+r.Header.Get("If-Match")+" "+string(body))
+
+		id := strings.TrimPrefix(r.URL.Path, "/items/")
+		switch {
+		case r.Method == http.MethodPost:
+			next++
+			items[fmt.Sprint(next)] = true
+			w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, next))
+			fmt.Fprintf(w, `{"data": {"items": [{"id": %d}]}}`, next)
+		case !items[id]:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			delete(items, id)
+		}
+	}))
+}
+
+func TestRun_FlowPassesExtractedValues(t *testing.T) {
+	var seen []string
+	server := itemServer(&seen)
+	defer server.Close()
+
+	s, err := ParseScenario([]byte(itemFlow), server.URL)
+	assert.NoError(t, err)
+	stats := Run(Options{Mode: "closed", Count: 8, VirtualUsers: 1}, s)
+
+	assert.Equal(t, []string{
+		`POST /items  {"name": "widget"}`,
+		`GET /items/41  `,
+		`PUT /items/41 "v41" {"id": 41, "name": "gadget"}`,
+		`DELETE /items/41  `,
+		`POST /items  {"name": "widget"}`,
+		`GET /items/42  `,
+		`PUT /items/42 "v42" {"id": 42, "name": "gadget"}`,
+		`DELETE /items/42  `,
+	}, seen)
+	assert.Equal(t, int64(2), stats.FlowsCompleted["item"])
+	assert.Zero(t, stats.FlowsAborted["item"])
+	assert.Equal(t, uint64(2), stats.Latencies[StatKey{Endpoint: "item/read", Method: "GET"}].Total)
+	assert.Equal(t, []FlowSummary{{Name: "item", Completed: 2}}, stats.Summary().Flows)
+}
+
+func TestRun_FlowAbortsAtFailingStep(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte(`{"other": 1}`))
+	}))
+	defer server.Close()
+
+	s, err := ParseScenario([]byte(`
+flows:
+  - steps:
+      - url: "{base}/login"
+        extract: {token: $.token}
+      - url: "{base}/account"
+`), server.URL)
+	assert.NoError(t, err)
+	stats := Run(Options{Mode: "closed", Count: 3, VirtualUsers: 1}, s)
+
+	// Without a token the flow starts over instead of going on to /account
+	assert.Equal(t, []string{"/login", "/login", "/login"}, paths)
+	assert.Equal(t, int64(3), stats.FlowsAborted["flow-1"])
+	assert.Zero(t, stats.FlowsCompleted["flow-1"])
+}
+
+func TestStats_FlowsMergeAndRoundTrip(t *testing.T) {
+	a, b := NewStats(), NewStats()
+	a.RecordFlow("checkout", true)
+	b.RecordFlow("checkout", false)
+	b.RecordFlow("browse", true)
+
+	data, err := json.Marshal(b)
+	assert.NoError(t, err)
+	decoded := &Stats{}
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	a.Merge(decoded)
+
+	assert.Equal(t, []FlowSummary{
+		{Name: "browse", Completed: 1},
+		{Name: "checkout", Completed: 1, Aborted: 1},
+	}, a.Summary().Flows)
+}
+
+func TestLookupPath(t *testing.T) {
+	doc := `{"id": 12345678901234567890, "user": {"name": "ann", "tags": ["a", "b"], "admin": false}, "odd key": {"x": null}}`
+	cases := map[string]string{
+		"$.id":               "12345678901234567890",
+		"$.user.name":        "ann",
+		"$['user'].tags[1]":  "b",
+		"$.user.tags":        `["a","b"]`,
+		"$.user.admin":       "false",
+		"$['odd key']":       `{"x":null}`,
+		"$.user.tags[2]":     "",
+		"$['odd key'].x":     "",
+		"$.user.name.length": "",
+	}
+
+	for spec, expected := range cases {
+		ex, err := parseExtractor("v", spec)
+		assert.NoError(t, err, spec)
+		step := &Step{extractors: []extractor{ex}}
+		vars := map[string]string{}
+		err = step.extract(&Result{Body: []byte(doc)}, vars)
+		if expected == "" {
+			assert.Error(t, err, spec)
+			continue
+		}
+		assert.NoError(t, err, spec)
+		assert.Equal(t, expected, vars["v"], spec)
+	}
+}
+
+func TestParseScenario_FlowErrors(t *testing.T) {
+	cases := map[string]string{
+		"scenario has no endpoints or flows": `flows: []`,
+		`flow "empty" has no steps`:          "flows:\n  - name: empty\n",
+		`unsupported method "FETCH"`:         "flows:\n  - steps:\n      - {url: http://x, method: fetch}\n",
+		"unsupported JSONPath":               "flows:\n  - steps:\n      - {url: http://x, extract: {id: $..id}}\n",
+		"use a JSONPath":                     "flows:\n  - steps:\n      - {url: http://x, extract: {id: id}}\n",
+		`variable name "base" is reserved`:   "flows:\n  - steps:\n      - {url: http://x, extract: {base: $.b}}\n",
+		"weight must not be negative":        "flows:\n  - weight: -1\n    steps:\n      - {url: http://x}\n",
+		"both endpoints and flows":           "endpoints:\n  - url: http://x\nflows:\n  - steps:\n      - {url: http://x}\n",
+	}
+
+	for expected, data := range cases {
+		s, err := ParseScenario([]byte(data), "")
+		assert.Nil(t, s, expected)
+		if assert.Error(t, err, expected) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
+
+func TestExpandJSON(t *testing.T) {
+	vars := map[string]string{"id": "41", "name": `say "hi" \ bye`, "user": `{"id":7}`}
+	body := `{"id": {id}, "name": "{name}", "note": "id {id}, \"{name}\"", "user": {user}, "other": "{other}"}`
+
+	expanded := expandJSON([]byte(body), vars)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(expanded, &doc), string(expanded))
+	assert.Equal(t, float64(41), doc["id"])
+	assert.Equal(t, `say "hi" \ bye`, doc["name"])
+	assert.Equal(t, `id 41, "`+vars["name"]+`"`, doc["note"])
+	assert.Equal(t, map[string]interface{}{"id": float64(7)}, doc["user"])
+	assert.Equal(t, "{other}", doc["other"])
+}
+
+func TestExpandURL(t *testing.T) {
+	vars := map[string]string{"id": "a/b c?", "q": "x&y=1 z"}
+
+	assert.Equal(t, "http://x/items/a%2Fb%20c%3F?q=x%26y%3D1+z&id=a%2Fb+c%3F&u={u}",
+		expandURL("http://x/items/{id}?q={q}&id={id}&u={u}", vars))
+	assert.Equal(t, "http://x/items/41", expandURL("http://x/items/{id}", map[string]string{"id": "41"}))
+}
+
+func TestStep_BuildRequestPadsAfterExpanding(t *testing.T) {
+	s, err := ParseScenario([]byte(`
+flows:
+  - steps:
+      - method: POST
+        url: http://x/items
+        payload: static
+        body: '{"name": "{name}"}'
+        size: 100B
+`), "")
+	assert.NoError(t, err)
+
+	req := s.Flows[0].Steps[0].BuildRequest(map[string]string{"name": strings.Repeat("a", 40)})
+
+	assert.Len(t, req.Body, 100)
+	assert.True(t, json.Valid(req.Body), string(req.Body))
+}
+
+func TestScenario_CheckMode(t *testing.T) {
+	s, err := ParseScenario([]byte("flows:\n  - steps:\n      - url: http://x\n"), "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.CheckMode("closed"))
+	assert.ErrorContains(t, s.CheckMode("open"), "flows need closed mode")
+	assert.NoError(t, DefaultScenario("http://x").CheckMode("open"))
+}
@@ -3,31 +3,520 @@ package generator
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"traffic-generator/spec"
 )
 
+// Options selects the engine mode and its pacing parameters
+type Options struct {
+	Mode         string        // "open" (default), "closed" or "replay"
+	Count        int           // stop after this many requests, 0 for no limit
+	Duration     time.Duration // stop after this long, 0 for no limit
+	Interval     time.Duration
+	VirtualUsers int
+	ThinkTime    time.Duration
+	Profile      *spec.Profile // optional staged load profile for open mode
+	Arrival      spec.Arrival  // arrival process for open mode, constant when nil
+	ResultLog    *ResultLog    // optional per-request NDJSON log
+	Capture      *Capture      // optional record of every request sent, for replay
+	Client       *Client       // shared HTTP client, DefaultClient when nil
+	Retry        *spec.RetryPolicy
+	Replay       Recording            // requests to send in replay mode
+	ReplaySpeed  float64              // 1 keeps the recorded timing, 2 is twice as fast, 0 is as fast as possible
+	Abort        *spec.ThresholdAbort // optional early stop when a threshold is breached
+	Metrics      *Metrics             // optional live counters served at /metrics
+	Progress     *Progress            // optional display of the run as it goes
+	Control      *Control             // optional pause, resume, rate and stop while the run goes
+	MaxInFlight  int                  // open mode: at most this many requests in flight, 0 for no limit
+	Overflow     string               // open mode, at MaxInFlight: "delay" (default) waits for a free worker, "drop" skips the request
+}
+
+// engine holds what every request of a run shares
+type engine struct {
+	scenario *Scenario
+	stats    *Stats
+	log      *ResultLog
+	capture  *Capture
+	client   *Client
+	retry    *spec.RetryPolicy
+	metrics  *Metrics
+	progress *Progress
+	control  *Control
+	start    time.Time
+
+	stop     chan struct{} // closed to end the run early
+	stopOnce sync.Once
+
+	mu sync.Mutex // serializes next so a seeded run builds the same request sequence
+}
+
+func newEngine(scenario *Scenario) *engine {
+	return &engine{scenario: scenario, stats: NewStats(), client: DefaultClient, start: time.Now(), stop: make(chan struct{})}
+}
+
+// next builds the next request from the scenario. Requests are built one at a
+// time, and captured in that order, so the sequence depends only on the seed.
+func (e *engine) next() *Request {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.captureRequest(e.scenario.NextRequest())
+}
+
+// captureRequest numbers request for the capture file, if there is one, and
+// returns the copy to send; sendRequest writes it out with the headers sent
+func (e *engine) captureRequest(request *Request) *Request {
+	if e.capture == nil {
+		return request
+	}
+	captured := *request
+	captured.captured = e.capture.record(request, time.Since(e.start))
+	return &captured
+}
+
+// halt ends the run early: no new requests are started, those in flight finish
+func (e *engine) halt() {
+	e.stopOnce.Do(func() { close(e.stop) })
+}
+
+// stopped reports whether the run has been halted
+func (e *engine) stopped() bool {
+	select {
+	case <-e.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// watch halts the run once an abortable threshold is breached, checking every second
+func (e *engine) watch(abort *spec.ThresholdAbort, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if failed, breached := checkAbort(abort, e.stats); breached {
+				fmt.Printf("Aborting run: threshold %q failed with %s\n", failed.Expr, failed.FormatActual())
+				e.halt()
+				return
+			}
+		}
+	}
+}
+
+// send builds the next request from the scenario, sends it and records the result
+func (e *engine) send() {
+	e.sendRequest(e.next())
+}
+
+// sendRequest sends request, retrying as the policy allows, and records each
+// attempt. It returns the outcome of the last attempt.
+func (e *engine) sendRequest(request *Request) (*Result, error) {
+	for attempt := 1; ; attempt++ {
+		// Send request to the chosen endpoint
+		e.metrics.Started(request, attempt)
+		e.progress.Started(attempt)
+		result, err := e.client.Do(request)
+		if attempt == 1 && request.captured != nil {
+			e.capture.write(request.captured, result.sentHeader)
+		}
+		e.metrics.Finished(result, err)
+		e.progress.Finished(result, err)
+		result.Attempt = attempt
+		if err != nil && e.progress == nil { // the progress display lists recent errors itself
+			fmt.Println("Request error:", err)
+		}
+
+		// A stopped run drains what is in flight rather than starting retries
+		retry := shouldRetry(e.retry, result, err) && !e.stopped()
+		e.stats.Record(result, err)
+		if e.log != nil {
+			e.log.WriteResult(result, err)
+		}
+		if !retry {
+			e.stats.RecordOutcome(result, err)
+			return result, err
+		}
+
+		// A stop during the backoff ends the request with the attempt it has
+		wait := time.NewTimer(backoff(e.retry, result))
+		select {
+		case <-wait.C:
+		case <-e.stop:
+			wait.Stop()
+			e.stats.RecordOutcome(result, err)
+			return result, err
+		}
+	}
+}
+
+// Run starts the engine selected by opts.Mode and returns the collected results
+func Run(opts Options, scenario *Scenario) *Stats {
+	e := newEngine(scenario)
+	e.log = opts.ResultLog
+	e.capture = opts.Capture
+	e.retry = opts.Retry
+	e.metrics = opts.Metrics
+	e.progress = opts.Progress
+	e.control = opts.Control
+	if opts.Client != nil {
+		e.client = opts.Client
+	}
+	if opts.Abort != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go e.watch(opts.Abort, done)
+	}
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = "open"
+	}
+	e.control.attach(mode, e.halt)
+
+	switch opts.Mode {
+	case "closed":
+		e.progress.Start(opts.Count, opts.Duration)
+		e.virtualUsers(opts.Count, opts.Duration, opts.VirtualUsers, opts.ThinkTime)
+		return e.stats
+	case "replay":
+		limit := opts.Duration
+		if opts.ReplaySpeed > 0 {
+			if d := time.Duration(float64(opts.Replay.Duration()) / opts.ReplaySpeed); limit <= 0 || d < limit {
+				limit = d
+			}
+		}
+		e.progress.Start(opts.Count, limit)
+		e.replay(opts.Replay, opts.ReplaySpeed, opts.Count, opts.Duration)
+		return e.stats
+	}
+
+	arrival := opts.Arrival
+	if arrival == nil {
+		arrival = spec.ConstantArrival{}
+	}
+	if opts.Profile != nil {
+		limit := opts.Profile.Duration()
+		if opts.Duration > 0 && opts.Duration < limit {
+			limit = opts.Duration
+		}
+		e.progress.Start(opts.Count, limit)
+		e.openLoop(opts.Count, limit, opts.Profile.RateAt, arrival, opts.MaxInFlight, opts.Overflow)
+		return e.stats
+	}
+	e.progress.Start(opts.Count, opts.Duration)
+	e.openLoop(opts.Count, opts.Duration, constantRate(opts.Interval), arrival, opts.MaxInFlight, opts.Overflow)
+	return e.stats
+}
+
 // Simulator function to generate and send API requests
-func Simulator(apiCount int, interval time.Duration, collectorURL string) {
+func Simulator(apiCount int, interval time.Duration, scenario *Scenario) *Stats {
+	e := newEngine(scenario)
+	e.openLoop(apiCount, 0, constantRate(interval), spec.ConstantArrival{}, 0, "")
+	return e.stats
+}
+
+// ProfileSimulator sends requests at the rate the profile gives for the current
+// point in the run. It stops when the profile ends or, if apiCount > 0, after apiCount requests.
+func ProfileSimulator(apiCount int, profile *spec.Profile, scenario *Scenario) *Stats {
+	e := newEngine(scenario)
+	e.openLoop(apiCount, profile.Duration(), profile.RateAt, spec.ConstantArrival{}, 0, "")
+	return e.stats
+}
+
+// constantRate turns a fixed interval into a rate function
+func constantRate(interval time.Duration) func(time.Duration) float64 {
+	rate := float64(time.Second) / float64(interval)
+	return func(time.Duration) float64 { return rate }
+}
+
+// maxPacingSleep bounds each wait so rate changes in a profile are picked up promptly
+const maxPacingSleep = 50 * time.Millisecond
+
+// openLoop sends requests at the target rate from rateAt, spaced by the arrival
+// process, without waiting for responses. It stops after limit (if > 0) or
+// after apiCount requests (if > 0), whichever comes first. With maxInFlight
+// set, a pool of that many workers sends the requests and overflow says what
+// happens to those falling due while every worker is busy.
+func (e *engine) openLoop(apiCount int, limit time.Duration, rateAt func(time.Duration) float64, arrival spec.Arrival, maxInFlight int, overflow string) {
 	var wg sync.WaitGroup
-	startTime := time.Now()
 
-	for i := 0; i < apiCount; i++ {
+	// The pacer streams the due time of each request, catching up on any it
+	// fell behind on; the dispatcher below hands them out
+	ticks := make(chan time.Time, 64)
+	rateAt = e.control.rateAt(rateAt)
+	if e.metrics != nil || e.progress != nil {
+		target := rateAt
+		rateAt = func(elapsed time.Duration) float64 {
+			rate := target(elapsed)
+			e.metrics.SetTargetRate(rate)
+			e.progress.SetTargetRate(rate)
+			return rate
+		}
+		defer e.metrics.SetTargetRate(0)
+	}
+	go pace(ticks, e.stop, e.control, apiCount, limit, rateAt, arrival)
+
+	var due, delayed, dropped int64
+	if maxInFlight <= 0 {
+		// Without a cap each request is built here, in order, and sent on its
+		// own goroutine which exits once the response arrives
+		for at := range ticks {
+			at, ok := e.hold(at)
+			if !ok {
+				continue
+			}
+			due++
+			request := e.next()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				e.stats.RecordStartLag(time.Since(at))
+				e.sendRequest(request)
+			}()
+		}
+	} else {
+		// A slot is taken for each request handed to the pool and given back
+		// when its response arrives, so at most maxInFlight are ever in flight
+		slots := make(chan struct{}, maxInFlight)
+		jobs := make(chan time.Time, maxInFlight)
+		for w := 0; w < maxInFlight; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for at := range jobs {
+					e.stats.RecordStartLag(time.Since(at))
+					e.send()
+					<-slots
+				}
+			}()
+		}
+
+		// Requests that fell due while the dispatcher waited for a slot are late too
+		var waitedUntil time.Time
+		for at := range ticks {
+			at, ok := e.hold(at)
+			if !ok {
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+				if at.Before(waitedUntil) {
+					delayed++
+					e.metrics.Delayed()
+					e.progress.Delayed()
+				}
+			default:
+				if overflow == spec.OverflowDrop {
+					due++
+					dropped++
+					e.metrics.Dropped()
+					e.progress.Dropped()
+					continue
+				}
+				select {
+				case slots <- struct{}{}:
+				case <-e.stop:
+					continue
+				}
+				// The run may have been paused or stopped while this request waited
+				if at, ok = e.hold(at); !ok {
+					<-slots
+					continue
+				}
+				waitedUntil = time.Now()
+				delayed++
+				e.metrics.Delayed()
+				e.progress.Delayed()
+			}
+			due++
+			jobs <- at
+		}
+		close(jobs)
+	}
+
+	wg.Wait() // Wait for all goroutines to finish
+	e.stats.RecordSchedule(due, delayed, dropped)
+	e.stats.Finish()
+	e.progress.Stop()
+	fmt.Printf("Total time taken: %.2f seconds\n", e.stats.Elapsed().Seconds())
+	fmt.Printf("Requests sent: %d\n", due-dropped)
+	if delayed > 0 || dropped > 0 {
+		fmt.Printf("In-flight cap of %d: %d requests delayed, %d dropped\n", maxInFlight, delayed, dropped)
+	}
+}
+
+// hold keeps a request due at from starting while the run is paused, moving its
+// due time on by the pause, and reports false once the run is stopped so the
+// requests the pacer already queued are dropped rather than sent
+func (e *engine) hold(at time.Time) (time.Time, bool) {
+	before := e.control.pausedFor()
+	e.control.wait(e.stop)
+	return at.Add(e.control.pausedFor() - before), !e.stopped()
+}
+
+// pace writes the due time of each request to ticks and closes it when the
+// run is over or stop is closed. Requests missed while the pacer slept or was
+// blocked are sent at once with their original due times, so the sent count
+// keeps to the schedule. While control holds the run paused no requests fall
+// due and the run time stands still.
+func pace(ticks chan<- time.Time, stop <-chan struct{}, control *Control, apiCount int, limit time.Duration, rateAt func(time.Duration) float64, arrival spec.Arrival) {
+	defer close(ticks)
+	clock := newRunClock(control)
+
+	// credit accumulates the integral of the target rate; a request is due
+	// each time it reaches the gap drawn from the arrival process
+	credit := 0.0
+	gap := arrival.Next()
+	last := clock.start
+	sent := 0
+
+	for apiCount <= 0 || sent < apiCount {
+		if control.wait(stop) {
+			last = time.Now() // no credit builds up while paused
+		}
+		now := time.Now()
+		elapsed := clock.elapsed()
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		// Past the limit, only the requests due before it are still sent
+		over := limit > 0 && elapsed >= limit
+		if over {
+			now, elapsed = now.Add(limit-elapsed), limit
+		}
+
+		rate := rateAt(elapsed) * arrival.Factor(elapsed)
+		credit += rate * now.Sub(last).Seconds()
+		last = now
+
+		for credit >= gap && (apiCount <= 0 || sent < apiCount) {
+			credit -= gap
+			gap = arrival.Next()
+			sent++
+			// the credit left over is what built up since this request fell due
+			ticks <- now.Add(-time.Duration(credit / rate * float64(time.Second)))
+		}
+		if over {
+			return
+		}
+
+		wait := maxPacingSleep
+		if rate > 0 {
+			if untilNext := time.Duration((gap - credit) / rate * float64(time.Second)); untilNext < wait {
+				wait = untilNext
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// runFlow takes a virtual user through one flow picked by weight, with
+// variables of its own. Every step is a request of the run and is followed by
+// think time. It reports false when the run ended before the flow did.
+func (e *engine) runFlow(claim func() bool, think func()) bool {
+	e.mu.Lock()
+	flow := e.scenario.PickFlow()
+	e.mu.Unlock()
+
+	vars := make(map[string]string)
+	for _, step := range flow.Steps {
+		if !claim() {
+			return false
+		}
+		e.mu.Lock()
+		request := e.captureRequest(step.BuildRequest(vars))
+		e.mu.Unlock()
+
+		result, err := e.sendRequest(request)
+		if err == nil && result.StatusCode >= 400 {
+			err = fmt.Errorf("status %d", result.StatusCode)
+		}
+		if err == nil {
+			if err = step.extract(result, vars); err != nil && e.progress == nil {
+				fmt.Printf("Flow %s aborted at %s: %v\n", flow.Name, step.Name, err)
+			}
+		}
+		think()
+		if err != nil {
+			e.stats.RecordFlow(flow.Name, false)
+			return true
+		}
+	}
+	e.stats.RecordFlow(flow.Name, true)
+	return true
+}
+
+// VirtualUserSimulator runs a closed loop: each user sends a request, waits for
+// the response, thinks, and repeats until apiCount requests have been sent in total
+func VirtualUserSimulator(apiCount, users int, thinkTime time.Duration, scenario *Scenario) *Stats {
+	e := newEngine(scenario)
+	e.virtualUsers(apiCount, 0, users, thinkTime)
+	return e.stats
+}
+
+// virtualUsers is the closed loop behind VirtualUserSimulator; it also stops
+// users from starting new requests once limit (if > 0) has passed
+func (e *engine) virtualUsers(apiCount int, limit time.Duration, users int, thinkTime time.Duration) {
+	var wg sync.WaitGroup
+	var sent int64
+	clock := newRunClock(e.control)
+
+	// claim takes one request out of the run's budget, reporting false once the run is over
+	claim := func() bool {
+		e.control.wait(e.stop)
+		if (limit > 0 && clock.elapsed() >= limit) || e.stopped() {
+			return false
+		}
+		n := atomic.AddInt64(&sent, 1)
+		if apiCount > 0 && n > int64(apiCount) {
+			atomic.AddInt64(&sent, -1)
+			return false
+		}
+		return true
+	}
+	think := func() {
+		select {
+		case <-time.After(thinkTime):
+		case <-e.stop:
+		}
+	}
+
+	for u := 0; u < users; u++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			request := GetRandomRequest() 
-
-			// Send request to collector
-			err := request.SendRequest(collectorURL)
-			if err != nil {
-				fmt.Println("Request error:", err)
+			for {
+				if len(e.scenario.Flows) > 0 {
+					if !e.runFlow(claim, think) {
+						return
+					}
+					continue
+				}
+				if !claim() {
+					return
+				}
+				e.send()
+				think()
 			}
 		}()
-		time.Sleep(interval) 
 	}
 
-	wg.Wait() // Wait for all goroutines to finish
-	// fmt.Printf("Total time taken: %v\n", time.Since(startTime))
-	fmt.Printf("Total time taken: %.2f seconds\n", time.Since(startTime).Seconds())
-
+	wg.Wait()
+	e.stats.Finish()
+	e.progress.Stop()
+	elapsed := e.stats.Elapsed().Seconds()
+	fmt.Printf("Total time taken: %.2f seconds\n", elapsed)
+	fmt.Printf("Requests sent: %d\n", atomic.LoadInt64(&sent))
+	if elapsed > 0 {
+		fmt.Printf("Throughput: %.2f requests/s with %d virtual users\n", float64(atomic.LoadInt64(&sent))/elapsed, users)
+	}
 }
@@ -0,0 +1,214 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
+)
+
+func TestVirtualUserSimulator_SendsExactCountWithBoundedConcurrency(t *testing.T) {
+	var received, inFlight, maxInFlight int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		for {
+			max := atomic.LoadInt64(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt64(&inFlight, -1)
+		atomic.AddInt64(&received, 1)
+	}))
+	defer server.Close()
+
+	VirtualUserSimulator(20, 3, time.Millisecond, DefaultScenario(server.URL))
+
+	assert.Equal(t, int64(20), atomic.LoadInt64(&received))
+	assert.LessOrEqual(t, atomic.LoadInt64(&maxInFlight), int64(3))
+}
+
+func TestProfileSimulator_FollowsProfile(t *testing.T) {
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&received, 1)
+	}))
+	defer server.Close()
+
+	// 0 -> 200/s over 200ms then 200/s for 100ms: about 20 + 20 requests
+	profile, err := spec.ParseProfile("ramp 200/s 200ms, hold 100ms", 0)
+	assert.NoError(t, err)
+
+	ProfileSimulator(0, profile, DefaultScenario(server.URL))
+
+	assert.InDelta(t, 40, atomic.LoadInt64(&received), 12)
+}
+
+func TestRun_StopsAtDuration(t *testing.T) {
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&received, 1)
+	}))
+	defer server.Close()
+
+	for _, mode := range []string{"open", "closed"} {
+		atomic.StoreInt64(&received, 0)
+		start := time.Now()
+
+		Run(Options{
+			Mode:         mode,
+			Duration:     200 * time.Millisecond,
+			Interval:     10 * time.Millisecond,
+			VirtualUsers: 1,
+			ThinkTime:    10 * time.Millisecond,
+		}, DefaultScenario(server.URL))
+
+		assert.Less(t, time.Since(start), time.Second, mode)
+		assert.InDelta(t, 20, atomic.LoadInt64(&received), 10, mode)
+	}
+}
+
+// seededRun runs the scenario with seed and returns the captured request sequence
+func seededRun(t *testing.T, seed int64, opts Options, url string) []string {
+	scenario, err := ParseScenario([]byte(`
+endpoints:
+  - name: items
+    url: "{base}/items/{rand_int}"
+    weight: 3
+    methods: {GET: 2, POST: 1, PUT: 1}
+    size: uniform 10B 200B
+  - name: orders
+    url: "{base}/orders"
+    methods: {POST: 1}
+    payload: template
+    template: {id: {type: uuid}, qty: {type: int, min: 1, max: 9}}
+`), url)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	opts.Capture, err = OpenCapture(path, seed)
+	assert.NoError(t, err)
+
+	SetSeed(seed)
+	Run(opts, scenario)
+	assert.NoError(t, opts.Capture.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var recs []CaptureRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var rec CaptureRecord
+		assert.NoError(t, json.Unmarshal([]byte(line), &rec))
+		recs = append(recs, rec)
+	}
+
+	// Lines are written as requests go out; seq is the order they were built in
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Seq < recs[j].Seq })
+	var sequence []string
+	for _, rec := range recs {
+		body := ""
+		if rec.Body != nil {
+			body = *rec.Body
+		}
+		sequence = append(sequence, fmt.Sprintf("%d %s %s %s", rec.Seq, rec.Method, rec.URL, body))
+	}
+	return sequence
+}
+
+func TestRun_SameSeedSameRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, opts := range []Options{
+		{Mode: "open", Count: 30, Interval: time.Millisecond},
+		{Mode: "closed", Count: 30, VirtualUsers: 4},
+	} {
+		first := seededRun(t, 99, opts, server.URL)
+		second := seededRun(t, 99, opts, server.URL)
+		other := seededRun(t, 100, opts, server.URL)
+
+		assert.Len(t, first, 30, opts.Mode)
+		assert.Equal(t, first, second, opts.Mode)
+		assert.NotEqual(t, first, other, opts.Mode)
+	}
+}
+
+func TestPace_CatchesUpMissedTicks(t *testing.T) {
+	ticks := make(chan time.Time)
+	start := time.Now()
+	go pace(ticks, make(chan struct{}), nil, 0, 300*time.Millisecond, func(time.Duration) float64 { return 1000 }, spec.ConstantArrival{})
+
+	// Nothing is read for the first 100ms; those requests come late with their own due times
+	time.Sleep(100 * time.Millisecond)
+	var due []time.Time
+	for at := range ticks {
+		due = append(due, at)
+	}
+
+	assert.InDelta(t, 300, len(due), 1)
+	assert.WithinDuration(t, start, due[0], 5*time.Millisecond)
+	for i := 1; i < len(due); i++ {
+		assert.False(t, due[i].Before(due[i-1]), "due times in order")
+	}
+}
+
+// concurrencyServer answers after delay and tracks the most requests it held at once
+func concurrencyServer(delay time.Duration, max *int64) *httptest.Server {
+	var inFlight int64
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		for {
+			m := atomic.LoadInt64(max)
+			if n <= m || atomic.CompareAndSwapInt64(max, m, n) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		atomic.AddInt64(&inFlight, -1)
+	}))
+}
+
+func TestRun_MaxInFlightDelays(t *testing.T) {
+	var max int64
+	server := concurrencyServer(20*time.Millisecond, &max)
+	defer server.Close()
+
+	stats := Run(Options{Count: 60, Interval: 2 * time.Millisecond, MaxInFlight: 3}, DefaultScenario(server.URL))
+
+	assert.LessOrEqual(t, atomic.LoadInt64(&max), int64(3))
+	assert.Equal(t, uint64(60), stats.Overall.Total)
+	assert.Equal(t, int64(60), stats.Due)
+	assert.Greater(t, stats.Delayed, int64(0))
+	assert.Zero(t, stats.Dropped)
+	assert.Equal(t, int64(60), stats.Summary().Schedule.Due)
+}
+
+func TestRun_MaxInFlightDrops(t *testing.T) {
+	var max int64
+	server := concurrencyServer(20*time.Millisecond, &max)
+	defer server.Close()
+
+	metrics := NewMetrics()
+	stats := Run(Options{Count: 60, Interval: 2 * time.Millisecond, MaxInFlight: 3, Overflow: spec.OverflowDrop, Metrics: metrics}, DefaultScenario(server.URL))
+
+	assert.LessOrEqual(t, atomic.LoadInt64(&max), int64(3))
+	assert.Equal(t, int64(60), stats.Due)
+	assert.Greater(t, stats.Dropped, int64(0))
+	assert.Equal(t, uint64(60-stats.Dropped), stats.Overall.Total)
+
+	var out strings.Builder
+	metrics.WriteTo(&out)
+	assert.Contains(t, out.String(), fmt.Sprintf("traffic_generator_requests_dropped_total %d\n", stats.Dropped))
+}
@@ -0,0 +1,152 @@
+package generator
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBucketBits sets the histogram precision: values are grouped into
+// buckets no wider than 1/64 of their magnitude (under 1.6% error)
+const subBucketBits = 7
+
+const (
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+)
+
+// Histogram is an HDR-style log-linear latency histogram with microsecond
+// resolution. Memory grows with the log of the largest value, not the count.
+type Histogram struct {
+	Counts []uint64 `json:"counts"`
+	Total  uint64   `json:"total"`
+	Sum    uint64   `json:"sum_us"`
+	Min    uint64   `json:"min_us"`
+	Max    uint64   `json:"max_us"`
+}
+
+// NewHistogram returns an empty histogram
+func NewHistogram() *Histogram {
+	return &Histogram{Min: math.MaxUint64}
+}
+
+// bucketIndex maps a value to its bucket
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	return shift*subBucketHalf + int(v>>uint(shift))
+}
+
+// bucketValue returns the midpoint of the values that map to bucket i
+func bucketValue(i int) uint64 {
+	if i < subBucketCount {
+		return uint64(i)
+	}
+	shift := i/subBucketHalf - 1
+	q := uint64(i - shift*subBucketHalf)
+	low := q << uint(shift)
+	high := ((q + 1) << uint(shift)) - 1
+	return low + (high-low)/2
+}
+
+// Record adds one latency sample
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	v := uint64(d / time.Microsecond)
+
+	i := bucketIndex(v)
+	if i >= len(h.Counts) {
+		grown := make([]uint64, i+1)
+		copy(grown, h.Counts)
+		h.Counts = grown
+	}
+	h.Counts[i]++
+	h.Total++
+	h.Sum += v
+	if v < h.Min {
+		h.Min = v
+	}
+	if v > h.Max {
+		h.Max = v
+	}
+}
+
+// Merge adds all samples of other into h
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.Total == 0 {
+		return
+	}
+	if len(other.Counts) > len(h.Counts) {
+		grown := make([]uint64, len(other.Counts))
+		copy(grown, h.Counts)
+		h.Counts = grown
+	}
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	if h.Total == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+	h.Total += other.Total
+	h.Sum += other.Sum
+}
+
+// Percentile returns the latency at or below which p percent of samples fall
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	if p >= 100 {
+		return time.Duration(h.Max) * time.Microsecond
+	}
+
+	rank := uint64(math.Ceil(p / 100 * float64(h.Total)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank {
+			v := bucketValue(i)
+			// the bucket midpoint can overshoot the real extremes
+			if v > h.Max {
+				v = h.Max
+			}
+			if v < h.Min {
+				v = h.Min
+			}
+			return time.Duration(v) * time.Microsecond
+		}
+	}
+	return time.Duration(h.Max) * time.Microsecond
+}
+
+// MinLatency returns the smallest recorded sample
+func (h *Histogram) MinLatency() time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	return time.Duration(h.Min) * time.Microsecond
+}
+
+// MaxLatency returns the largest recorded sample
+func (h *Histogram) MaxLatency() time.Duration {
+	return time.Duration(h.Max) * time.Microsecond
+}
+
+// Mean returns the average of all samples
+func (h *Histogram) Mean() time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	return time.Duration(h.Sum/h.Total) * time.Microsecond
+}
@@ -0,0 +1,75 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram_Percentiles(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	assert.Equal(t, uint64(1000), h.Total)
+	assert.Equal(t, time.Millisecond, h.MinLatency())
+	assert.Equal(t, time.Second, h.MaxLatency())
+	assert.InEpsilon(t, float64(500500*time.Microsecond), float64(h.Mean()), 0.001)
+
+	// log-linear buckets keep every percentile within about 1.6%
+	for p, want := range map[float64]time.Duration{
+		50:   500 * time.Millisecond,
+		90:   900 * time.Millisecond,
+		99:   990 * time.Millisecond,
+		99.9: 999 * time.Millisecond,
+	} {
+		assert.InEpsilon(t, float64(want), float64(h.Percentile(p)), 0.016, "p%v", p)
+	}
+	assert.Equal(t, time.Second, h.Percentile(100))
+}
+
+func TestHistogram_SmallValuesAreExact(t *testing.T) {
+	h := NewHistogram()
+	for i := 0; i < 100; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	assert.Equal(t, 49*time.Microsecond, h.Percentile(50))
+	assert.Equal(t, time.Duration(0), h.MinLatency())
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	a.Record(2 * time.Millisecond)
+	b.Record(time.Millisecond)
+	b.Record(10 * time.Second)
+
+	a.Merge(b)
+
+	assert.Equal(t, uint64(3), a.Total)
+	assert.Equal(t, time.Millisecond, a.MinLatency())
+	assert.Equal(t, 10*time.Second, a.MaxLatency())
+	assert.InEpsilon(t, float64(2*time.Millisecond), float64(a.Percentile(50)), 0.016)
+}
+
+func TestStats_RecordAndReport(t *testing.T) {
+	s := NewStats()
+	s.Record(&Result{Endpoint: "collect", Method: "POST", Latency: 3 * time.Millisecond}, nil)
+	s.Record(&Result{Endpoint: "collect", Method: "GET", Latency: time.Millisecond}, nil)
+	s.Record(nil, errors.New("connection refused"))
+	s.Finish()
+
+	assert.Equal(t, int64(1), s.Errors)
+	assert.Equal(t, uint64(2), s.Overall.Total)
+	assert.Equal(t, uint64(1), s.Latencies[StatKey{"collect", "POST"}].Total)
+
+	var out bytes.Buffer
+	s.PrintLatencyReport(&out)
+	assert.Contains(t, out.String(), "p99.9")
+	assert.Contains(t, out.String(), "POST")
+	assert.Contains(t, out.String(), "Failed requests: 1")
+}
@@ -0,0 +1,249 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricBuckets are the upper bounds, in seconds, of the exported latency histograms
+var metricBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics holds live counters for a run and serves them at /metrics in the
+// Prometheus text format. Every attempt is counted, retries included.
+type Metrics struct {
+	mu         sync.Mutex
+	inFlight   int64
+	targetRate float64
+	sent       map[string]float64    // by method
+	responses  map[[2]string]float64 // by method and status
+	succeeded  map[string]float64    // by method
+	failed     map[[2]string]float64 // by method and reason, see classifyError
+	retries    map[string]float64    // by method
+	delayed    float64               // started late because of the in-flight cap
+	dropped    float64               // skipped because of the in-flight cap
+	latency    map[StatKey]*metricHistogram
+}
+
+type metricHistogram struct {
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics returns an empty set of run metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		sent:      make(map[string]float64),
+		responses: make(map[[2]string]float64),
+		succeeded: make(map[string]float64),
+		failed:    make(map[[2]string]float64),
+		retries:   make(map[string]float64),
+		latency:   make(map[StatKey]*metricHistogram),
+	}
+}
+
+// SetTargetRate records the rate, in requests per second, the engine is aiming for
+func (m *Metrics) SetTargetRate(rate float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.targetRate = rate
+	m.mu.Unlock()
+}
+
+// Started counts a request going out
+func (m *Metrics) Started(request *Request, attempt int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight++
+	m.sent[request.Method]++
+	if attempt > 1 {
+		m.retries[request.Method]++
+	}
+}
+
+// Finished records the outcome of a request counted by Started
+func (m *Metrics) Finished(result *Result, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+
+	switch {
+	case err != nil:
+		m.failed[[2]string{result.Method, classifyError(err)}]++
+		return
+	case result.StatusCode >= 400:
+		m.failed[[2]string{result.Method, "http_" + strconv.Itoa(result.StatusCode)}]++
+	default:
+		m.succeeded[result.Method]++
+	}
+	m.responses[[2]string{result.Method, strconv.Itoa(result.StatusCode)}]++
+
+	key := StatKey{Endpoint: result.Endpoint, Method: result.Method}
+	h := m.latency[key]
+	if h == nil {
+		h = &metricHistogram{counts: make([]uint64, len(metricBuckets)+1)}
+		m.latency[key] = h
+	}
+	seconds := result.Latency.Seconds()
+	h.counts[sort.SearchFloat64s(metricBuckets, seconds)]++
+	h.sum += seconds
+	h.count++
+}
+
+// Delayed counts a request started late because the in-flight cap was reached
+func (m *Metrics) Delayed() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.delayed++
+	m.mu.Unlock()
+}
+
+// Dropped counts a request skipped because the in-flight cap was reached
+func (m *Metrics) Dropped() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.dropped++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(rw, r)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(rw)
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("traffic_generator_requests_in_flight", "gauge", "Requests sent and waiting for a response.")
+	fmt.Fprintf(&b, "traffic_generator_requests_in_flight %d\n", m.inFlight)
+
+	header("traffic_generator_target_rate", "gauge", "Requests per second the generator is aiming for, 0 outside open mode.")
+	fmt.Fprintf(&b, "traffic_generator_target_rate %s\n", formatMetric(m.targetRate))
+
+	header("traffic_generator_requests_sent_total", "counter", "Requests sent, retries included.")
+	writeCounters(&b, "traffic_generator_requests_sent_total", m.sent, "method")
+
+	header("traffic_generator_retries_total", "counter", "Retry attempts sent.")
+	writeCounters(&b, "traffic_generator_retries_total", m.retries, "method")
+
+	header("traffic_generator_requests_delayed_total", "counter", "Requests started late because the in-flight cap was reached.")
+	fmt.Fprintf(&b, "traffic_generator_requests_delayed_total %s\n", formatMetric(m.delayed))
+
+	header("traffic_generator_requests_dropped_total", "counter", "Requests skipped because the in-flight cap was reached.")
+	fmt.Fprintf(&b, "traffic_generator_requests_dropped_total %s\n", formatMetric(m.dropped))
+
+	header("traffic_generator_responses_total", "counter", "Responses received, by status code.")
+	writePairCounters(&b, "traffic_generator_responses_total", m.responses, "method", "status")
+
+	header("traffic_generator_requests_succeeded_total", "counter", "Requests answered with a status below 400.")
+	writeCounters(&b, "traffic_generator_requests_succeeded_total", m.succeeded, "method")
+
+	header("traffic_generator_requests_failed_total", "counter", "Requests that got an error status or no response.")
+	writePairCounters(&b, "traffic_generator_requests_failed_total", m.failed, "method", "reason")
+
+	header("traffic_generator_request_duration_seconds", "histogram", "Latency of answered requests.")
+	keys := make([]StatKey, 0, len(m.latency))
+	for k := range m.latency {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Endpoint != keys[j].Endpoint {
+			return keys[i].Endpoint < keys[j].Endpoint
+		}
+		return keys[i].Method < keys[j].Method
+	})
+	for _, k := range keys {
+		h := m.latency[k]
+		labels := fmt.Sprintf(`endpoint="%s",method="%s"`, escapeLabel(k.Endpoint), escapeLabel(k.Method))
+		var cumulative uint64
+		for i, c := range h.counts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(metricBuckets) {
+				le = formatMetric(metricBuckets[i])
+			}
+			fmt.Fprintf(&b, "traffic_generator_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, le, cumulative)
+		}
+		fmt.Fprintf(&b, "traffic_generator_request_duration_seconds_sum{%s} %s\n", labels, formatMetric(h.sum))
+		fmt.Fprintf(&b, "traffic_generator_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeCounters(b *strings.Builder, name string, counts map[string]float64, label string) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{%s=\"%s\"} %s\n", name, label, escapeLabel(k), formatMetric(counts[k]))
+	}
+}
+
+func writePairCounters(b *strings.Builder, name string, counts map[[2]string]float64, first, second string) {
+	keys := make([][2]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{%s=\"%s\",%s=\"%s\"} %s\n", name, first, escapeLabel(k[0]), second, escapeLabel(k[1]), formatMetric(counts[k]))
+	}
+}
+
+// escapeLabel escapes a label value as the text format requires
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeMetrics serves m at addr in the background; errors are printed, not fatal,
+// so a busy port doesn't stop the run
+func ServeMetrics(addr string, m *Metrics) {
+	server := &http.Server{Addr: addr, Handler: m, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			fmt.Println("Metrics server error:", err)
+		}
+	}()
+}
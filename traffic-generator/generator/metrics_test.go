@@ -0,0 +1,68 @@
+package generator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_WritesPrometheusText(t *testing.T) {
+	m := NewMetrics()
+	get := &Request{Endpoint: "collect", Method: "GET"}
+	m.SetTargetRate(50)
+	m.Started(get, 1)
+	m.Finished(&Result{Endpoint: "collect", Method: "GET", StatusCode: 200, Latency: 3 * time.Millisecond}, nil)
+	m.Started(get, 2)
+	m.Finished(&Result{Endpoint: "collect", Method: "GET", StatusCode: 503, Latency: 2 * time.Second}, nil)
+	m.Started(get, 1)
+	m.Finished(&Result{Endpoint: "collect", Method: "GET"}, syscall.ECONNREFUSED)
+	m.Started(&Request{Endpoint: `a"b`, Method: "POST"}, 1)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	for _, line := range []string{
+		"# TYPE traffic_generator_requests_in_flight gauge",
+		"traffic_generator_requests_in_flight 1",
+		"traffic_generator_target_rate 50",
+		`traffic_generator_requests_sent_total{method="GET"} 3`,
+		`traffic_generator_requests_sent_total{method="POST"} 1`,
+		`traffic_generator_retries_total{method="GET"} 1`,
+		`traffic_generator_responses_total{method="GET",status="503"} 1`,
+		`traffic_generator_requests_succeeded_total{method="GET"} 1`,
+		`traffic_generator_requests_failed_total{method="GET",reason="connection_refused"} 1`,
+		`traffic_generator_requests_failed_total{method="GET",reason="http_503"} 1`,
+		`traffic_generator_request_duration_seconds_bucket{endpoint="collect",method="GET",le="0.005"} 1`,
+		`traffic_generator_request_duration_seconds_bucket{endpoint="collect",method="GET",le="1"} 1`,
+		`traffic_generator_request_duration_seconds_bucket{endpoint="collect",method="GET",le="+Inf"} 2`,
+		`traffic_generator_request_duration_seconds_count{endpoint="collect",method="GET"} 2`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/other", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRun_UpdatesMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	m := NewMetrics()
+	Run(Options{Count: 10, Interval: time.Millisecond, Metrics: m}, DefaultScenario(server.URL))
+
+	var b strings.Builder
+	m.WriteTo(&b)
+	assert.Contains(t, b.String(), "traffic_generator_requests_in_flight 0\n")
+	assert.Contains(t, b.String(), "traffic_generator_target_rate 0\n")
+	assert.Contains(t, b.String(), `traffic_generator_request_duration_seconds_count{endpoint="default"`)
+}
@@ -0,0 +1,37 @@
+package generator
+
+import (
+	"bytes"
+)
+
+// PadJSON grows body to size bytes without breaking it as JSON. Objects get a
+// "padding" string field; anything else gets trailing whitespace, which JSON
+// ignores. Bodies already at or above size are returned unchanged.
+func PadJSON(body []byte, size int) []byte {
+	missing := size - len(body)
+	if missing <= 0 {
+		return body
+	}
+
+	trimmed := bytes.TrimRight(body, " \t\r\n")
+	if len(trimmed) < 2 || trimmed[0] != '{' || trimmed[len(trimmed)-1] != '}' {
+		return append(body, bytes.Repeat([]byte(" "), missing)...)
+	}
+
+	field := `"padding":""`
+	if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+		field = "," + field
+	}
+	fill := size - len(trimmed) - len(field)
+	if fill < 0 {
+		// too close to the target for a field, whitespace still fits
+		return append(body, bytes.Repeat([]byte(" "), missing)...)
+	}
+
+	out := make([]byte, 0, size)
+	out = append(out, trimmed[:len(trimmed)-1]...)
+	out = append(out, field[:len(field)-1]...)
+	out = append(out, bytes.Repeat([]byte("x"), fill)...)
+	out = append(out, `"}`...)
+	return out
+}
@@ -0,0 +1,30 @@
+package generator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPadJSON(t *testing.T) {
+	bodies := []string{`{"id":1,"name":"x"}`, `{}`, `[1,2,3]`, `"text"`}
+
+	for _, body := range bodies {
+		for _, size := range []int{0, len(body) + 1, len(body) + 5, 4096} {
+			padded := PadJSON([]byte(body), size)
+
+			assert.True(t, json.Valid(padded), "%s padded to %d", body, size)
+			if size > len(body) {
+				assert.Len(t, padded, size)
+			} else {
+				assert.Equal(t, body, string(padded))
+			}
+		}
+	}
+
+	var object map[string]interface{}
+	assert.NoError(t, json.Unmarshal(PadJSON([]byte(`{"id":1}`), 100), &object))
+	assert.Equal(t, 1.0, object["id"])
+	assert.Contains(t, object, "padding")
+}
@@ -0,0 +1,322 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressWindow is how many recent seconds the rate and latency figures cover
+const progressWindow = 10
+
+// recentErrorCount is how many error messages the live view keeps
+const recentErrorCount = 5
+
+// Progress shows how a run is going while it runs: redrawn in place on a
+// terminal, or as one plain line per interval when output goes to a file or pipe
+type Progress struct {
+	out      io.Writer
+	live     bool
+	interval time.Duration
+
+	mu         sync.Mutex
+	start      time.Time
+	count      int           // planned requests, 0 when the run is not count limited
+	limit      time.Duration // planned run length, 0 when the run is not time limited
+	targetRate float64
+	inFlight   int64
+	sent       int64 // first attempts
+	statuses   map[int]int64
+	errors     int64
+	delayed    int64    // by the in-flight cap
+	dropped    int64    // by the in-flight cap
+	recent     []string // newest last
+	unseen     int      // errors since the last plain line
+
+	// one slot per second of the last progressWindow seconds
+	slotSecond []int64
+	slotCount  []int64
+	slotLat    []*Histogram
+
+	lines int // lines drawn by the last live frame
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewProgress returns a display writing to out: live frames every 500ms when
+// live is set, otherwise a plain line every interval
+func NewProgress(out io.Writer, live bool, interval time.Duration) *Progress {
+	if live {
+		interval = 500 * time.Millisecond
+	}
+	p := &Progress{
+		out:        out,
+		live:       live,
+		interval:   interval,
+		statuses:   make(map[int]int64),
+		slotSecond: make([]int64, progressWindow),
+		slotCount:  make([]int64, progressWindow),
+		slotLat:    make([]*Histogram, progressWindow),
+	}
+	for i := range p.slotLat {
+		p.slotSecond[i] = -1
+		p.slotLat[i] = NewHistogram()
+	}
+	return p
+}
+
+// IsTerminal reports whether f is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start begins drawing; count and limit, when set, give the remaining time
+func (p *Progress) Start(count int, limit time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.start, p.count, p.limit = time.Now(), count, limit
+	p.done = make(chan struct{})
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				p.draw()
+			}
+		}
+	}()
+}
+
+// Stop draws the final state and ends the display
+func (p *Progress) Stop() {
+	if p == nil || p.done == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+	p.draw()
+	p.done = nil
+}
+
+// SetTargetRate records the rate, in requests per second, the engine is aiming for
+func (p *Progress) SetTargetRate(rate float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.targetRate = rate
+	p.mu.Unlock()
+}
+
+// Started counts a request going out
+func (p *Progress) Started(attempt int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.inFlight++
+	if attempt == 1 {
+		p.sent++
+	}
+	p.mu.Unlock()
+}
+
+// Delayed counts a request started late because the in-flight cap was reached
+func (p *Progress) Delayed() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.delayed++
+	p.mu.Unlock()
+}
+
+// Dropped counts a request skipped because the in-flight cap was reached
+func (p *Progress) Dropped() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.dropped++
+	p.mu.Unlock()
+}
+
+// Finished records the outcome of a request counted by Started
+func (p *Progress) Finished(result *Result, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+
+	now := time.Now()
+	second := int64(now.Sub(p.start) / time.Second)
+	slot := int(second % progressWindow)
+	if p.slotSecond[slot] != second {
+		p.slotSecond[slot], p.slotCount[slot] = second, 0
+		p.slotLat[slot] = NewHistogram()
+	}
+	p.slotCount[slot]++
+
+	if err != nil {
+		p.errors++
+		p.unseen++
+		p.recent = append(p.recent, fmt.Sprintf("%s %s %s: %v", now.Format("15:04:05"), result.Method, result.Endpoint, err))
+		if len(p.recent) > recentErrorCount {
+			p.recent = p.recent[1:]
+		}
+		return
+	}
+	p.statuses[result.StatusCode]++
+	p.slotLat[slot].Record(result.Latency)
+}
+
+// progressState is one consistent view of the counters for drawing
+type progressState struct {
+	elapsed, remaining time.Duration
+	sent               int64
+	count              int
+	rate, targetRate   float64
+	inFlight           int64
+	delayed, dropped   int64
+	p50, p99           time.Duration
+	statuses           string
+	recent             []string
+	unseen             int
+}
+
+func (p *Progress) state() progressState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := progressState{
+		elapsed:    time.Since(p.start),
+		sent:       p.sent,
+		count:      p.count,
+		targetRate: p.targetRate,
+		inFlight:   p.inFlight,
+		delayed:    p.delayed,
+		dropped:    p.dropped,
+		recent:     append([]string(nil), p.recent...),
+		unseen:     p.unseen,
+	}
+	p.unseen = 0
+
+	switch {
+	case p.limit > 0:
+		s.remaining = p.limit - s.elapsed
+		if s.remaining < 0 {
+			s.remaining = 0
+		}
+	case p.count > 0 && p.sent > 0:
+		s.remaining = time.Duration(float64(s.elapsed) * float64(int64(p.count)-p.sent) / float64(p.sent))
+	default:
+		s.remaining = -1 // unknown
+	}
+
+	// The rate covers the finished seconds of the window, the latency all of it
+	current := int64(s.elapsed / time.Second)
+	window := NewHistogram()
+	var completed int64
+	for i, second := range p.slotSecond {
+		if second < 0 || second <= current-progressWindow {
+			continue
+		}
+		window.Merge(p.slotLat[i])
+		if second < current {
+			completed += p.slotCount[i]
+		}
+	}
+	if finished := min(current, progressWindow-1); finished > 0 {
+		s.rate = float64(completed) / float64(finished)
+	}
+	s.p50, s.p99 = window.Percentile(50), window.Percentile(99)
+
+	codes := make([]int, 0, len(p.statuses))
+	for code := range p.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	var parts []string
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%d: %d", code, p.statuses[code]))
+	}
+	if p.errors > 0 {
+		parts = append(parts, fmt.Sprintf("errors: %d", p.errors))
+	}
+	s.statuses = strings.Join(parts, "  ")
+	return s
+}
+
+func (p *Progress) draw() {
+	s := p.state()
+
+	remaining := "-"
+	if s.remaining >= 0 {
+		remaining = s.remaining.Round(time.Second).String()
+	}
+	sent := fmt.Sprint(s.sent)
+	if s.count > 0 {
+		sent = fmt.Sprintf("%d/%d", s.sent, s.count)
+	}
+	target := "-"
+	if s.targetRate > 0 {
+		target = fmt.Sprintf("%.1f/s", s.targetRate)
+	}
+
+	var capped string
+	if s.delayed > 0 || s.dropped > 0 {
+		capped = fmt.Sprintf(" (cap reached: %d delayed, %d dropped)", s.delayed, s.dropped)
+	}
+
+	if !p.live {
+		line := fmt.Sprintf("[%s] sent %s, remaining %s, rate %.1f/s (target %s), in flight %d%s, p50 %v, p99 %v",
+			s.elapsed.Round(time.Second), sent, remaining, s.rate, target, s.inFlight, capped, s.p50, s.p99)
+		if s.statuses != "" {
+			line += ", " + s.statuses
+		}
+		if s.unseen > 0 && len(s.recent) > 0 {
+			line += ", last error " + s.recent[len(s.recent)-1]
+		}
+		fmt.Fprintln(p.out, line)
+		return
+	}
+
+	lines := []string{
+		fmt.Sprintf("Elapsed %-10s Remaining %-10s Sent %s", s.elapsed.Round(time.Second), remaining, sent),
+		fmt.Sprintf("Rate %.1f/s (target %s)   In flight %d%s", s.rate, target, s.inFlight, capped),
+		fmt.Sprintf("Latency (last %ds)   p50 %v   p99 %v", progressWindow, s.p50, s.p99),
+		"Status  " + s.statuses,
+	}
+	if len(s.recent) > 0 {
+		lines = append(lines, "Recent errors:")
+		for _, e := range s.recent {
+			lines = append(lines, "  "+e)
+		}
+	}
+
+	var b strings.Builder
+	if p.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA\x1b[J", p.lines) // back to the top of the last frame and clear it
+	}
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	io.WriteString(p.out, b.String())
+	p.lines = len(lines)
+}
@@ -0,0 +1,53 @@
+package generator
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgress_PlainLines(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	progress := NewProgress(&out, false, 50*time.Millisecond)
+	Run(Options{Count: 20, Interval: 10 * time.Millisecond, Progress: progress}, DefaultScenario(server.URL))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Greater(t, len(lines), 1)
+	last := lines[len(lines)-1]
+	assert.Contains(t, last, "sent 20/20")
+	assert.Contains(t, last, "target 100.0/s")
+	assert.Contains(t, last, "in flight 0")
+	assert.Contains(t, last, "200: 20")
+	assert.NotContains(t, out.String(), "\x1b[")
+}
+
+func TestProgress_LiveFrame(t *testing.T) {
+	var out bytes.Buffer
+	progress := NewProgress(&out, true, 0)
+	progress.Start(0, time.Minute)
+
+	request := &Request{Endpoint: "collect", Method: "GET"}
+	progress.Started(1)
+	progress.Finished(&Result{Endpoint: "collect", Method: "GET", StatusCode: 503, Latency: 4 * time.Millisecond}, nil)
+	progress.Started(1)
+	progress.Finished(&Result{Endpoint: request.Endpoint, Method: request.Method}, syscall.ECONNREFUSED)
+	time.Sleep(600 * time.Millisecond)
+	progress.Stop()
+
+	frames := out.String()
+	assert.Contains(t, frames, "Remaining 59s")
+	assert.Contains(t, frames, "Status  503: 1  errors: 1")
+	assert.Contains(t, frames, "p50 4ms")
+	assert.Contains(t, frames, "Recent errors:\n  ")
+	assert.Contains(t, frames, "GET collect: connection refused")
+	assert.Contains(t, frames, "\x1b[", "later frames redraw over the first")
+}
@@ -0,0 +1,312 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"traffic-generator/spec"
+)
+
+// RecordedRequest is one request of a recording and when it was sent,
+// relative to the first request of the recording
+type RecordedRequest struct {
+	Offset time.Duration
+	*Request
+}
+
+// Recording is a recorded traffic file ready to be replayed, in send order
+type Recording []RecordedRequest
+
+// Duration is the time between the first and the last recorded request
+func (rec Recording) Duration() time.Duration {
+	if len(rec) == 0 {
+		return 0
+	}
+	return rec[len(rec)-1].Offset
+}
+
+// replayedLine is one NDJSON line. The field names match LogRecord, so a result
+// log replays too, though only roughly: its ts is when each response finished,
+// each retry has a line of its own and no bodies are logged. Capture files are
+// the exact recordings.
+type replayedLine struct {
+	Time     time.Time         `json:"ts"`
+	OffsetMs *float64          `json:"offset_ms"` // used instead of ts when set
+	Endpoint string            `json:"endpoint"`
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Path     string            `json:"path"` // alternative to url, joined to the target
+	Headers  map[string]string `json:"headers"`
+	Body     json.RawMessage   `json:"body"` // a string is sent as is, other JSON values are sent encoded
+
+	BodyBase64 string `json:"body_base64"` // binary bodies in capture files
+}
+
+// harFile is the part of a HAR export the replay uses
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime time.Time `json:"startedDateTime"`
+			Request         struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// skippedHeaders are set by the client for each connection and are not replayed
+var skippedHeaders = map[string]bool{
+	"host": true, "content-length": true, "connection": true, "keep-alive": true,
+	"proxy-connection": true, "transfer-encoding": true, "upgrade": true, "te": true, "trailer": true,
+}
+
+// LoadRecording reads an NDJSON or HAR recording. HAR is recognized by a .har
+// extension. When target is set, every request is sent to its scheme and host
+// instead of the recorded one; recordings with bare paths need a target.
+func LoadRecording(path, target string) (Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read recording: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".har") {
+		return ParseHAR(data, target)
+	}
+	return ParseNDJSONRecording(bytes.NewReader(data), target)
+}
+
+// ParseNDJSONRecording reads one request per line. Lines need a method and a
+// url or path; blank lines are skipped.
+func ParseNDJSONRecording(r io.Reader, target string) (Recording, error) {
+	var rec Recording
+	var first time.Time
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), spec.MaxPayloadSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var l replayedLine
+		if err := json.Unmarshal(text, &l); err != nil {
+			return nil, fmt.Errorf("recording line %d: %w", line, err)
+		}
+
+		rawURL := l.URL
+		if rawURL == "" {
+			rawURL = l.Path
+		}
+		if l.Method == "" || rawURL == "" {
+			return nil, fmt.Errorf("recording line %d: method and url or path are required", line)
+		}
+		request, err := newReplayedRequest(l.Endpoint, l.Method, rawURL, target)
+		if err != nil {
+			return nil, fmt.Errorf("recording line %d: %w", line, err)
+		}
+		for k, v := range l.Headers {
+			if !skippedHeaders[strings.ToLower(k)] {
+				request.Headers[k] = v
+			}
+		}
+		if request.Body, err = replayedBody(l.Body); err != nil {
+			return nil, fmt.Errorf("recording line %d: %w", line, err)
+		}
+		if l.BodyBase64 != "" {
+			if request.Body, err = base64.StdEncoding.DecodeString(l.BodyBase64); err != nil {
+				return nil, fmt.Errorf("recording line %d: invalid body_base64: %w", line, err)
+			}
+		}
+
+		var offset time.Duration
+		switch {
+		case l.OffsetMs != nil:
+			offset = time.Duration(*l.OffsetMs * float64(time.Millisecond))
+		case !l.Time.IsZero():
+			if first.IsZero() {
+				first = l.Time
+			}
+			offset = l.Time.Sub(first)
+		}
+		rec = append(rec, RecordedRequest{Offset: offset, Request: request})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read recording: %w", err)
+	}
+
+	return rec.sorted()
+}
+
+// ParseHAR reads the requests of a browser HAR export
+func ParseHAR(data []byte, target string) (Recording, error) {
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("unable to parse HAR file: %w", err)
+	}
+
+	var rec Recording
+	var first time.Time
+	for i, entry := range har.Log.Entries {
+		request, err := newReplayedRequest("", entry.Request.Method, entry.Request.URL, target)
+		if err != nil {
+			return nil, fmt.Errorf("HAR entry %d: %w", i+1, err)
+		}
+		for _, h := range entry.Request.Headers {
+			// HTTP/2 exports carry pseudo-headers such as :authority
+			if !strings.HasPrefix(h.Name, ":") && !skippedHeaders[strings.ToLower(h.Name)] {
+				request.Headers[h.Name] = h.Value
+			}
+		}
+		if entry.Request.PostData != nil {
+			request.Body = []byte(entry.Request.PostData.Text)
+			if _, ok := request.Headers["Content-Type"]; !ok && entry.Request.PostData.MimeType != "" {
+				request.Headers["Content-Type"] = entry.Request.PostData.MimeType
+			}
+		}
+
+		if first.IsZero() {
+			first = entry.StartedDateTime
+		}
+		rec = append(rec, RecordedRequest{Offset: entry.StartedDateTime.Sub(first), Request: request})
+	}
+
+	return rec.sorted()
+}
+
+// sorted orders the recording by offset and rebases it so the first request is at zero
+func (rec Recording) sorted() (Recording, error) {
+	if len(rec) == 0 {
+		return nil, fmt.Errorf("recording has no requests")
+	}
+	sort.SliceStable(rec, func(i, j int) bool { return rec[i].Offset < rec[j].Offset })
+	start := rec[0].Offset
+	for i := range rec {
+		rec[i].Offset -= start
+	}
+	return rec, nil
+}
+
+// newReplayedRequest builds the request for a recorded method and URL, moved to target when set
+func newReplayedRequest(endpoint, method, rawURL, target string) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q: %w", rawURL, err)
+	}
+
+	// Stats are grouped by the recorded endpoint name, or by recorded path when there is none
+	if endpoint == "" {
+		endpoint = u.Path
+		if endpoint == "" {
+			endpoint = "/"
+		}
+	}
+
+	if target != "" {
+		base, err := url.Parse(target)
+		if err != nil || base.Host == "" {
+			return nil, fmt.Errorf("invalid replay target %q", target)
+		}
+		u.Scheme, u.Host = base.Scheme, base.Host
+		u.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+		u.RawPath = ""
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("url %q has no host, set a replay target", rawURL)
+	}
+
+	return &Request{
+		Endpoint: endpoint,
+		Method:   strings.ToUpper(method),
+		URL:      u.String(),
+		Headers:  map[string]string{},
+	}, nil
+}
+
+// replayedBody decodes an NDJSON body: strings are sent as is, other values as their JSON
+func replayedBody(raw json.RawMessage) ([]byte, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return []byte(s), nil
+	}
+	return []byte(raw), nil
+}
+
+// replay sends the recording in order. speed scales the recorded gaps (2 replays
+// twice as fast); 0 sends each request as soon as the previous one was dispatched.
+// Like the open loop it never waits for responses, and it stops after apiCount
+// requests or limit if either is set.
+func (e *engine) replay(rec Recording, speed float64, apiCount int, limit time.Duration) {
+	var wg sync.WaitGroup
+	clock := newRunClock(e.control)
+
+	sent := 0
+	for _, recorded := range rec {
+		if apiCount > 0 && sent >= apiCount {
+			break
+		}
+		if speed > 0 {
+			due := time.Duration(float64(recorded.Offset) / speed)
+			if limit > 0 && due >= limit {
+				break
+			}
+			// Waits are bounded so a pause is noticed before the next request goes out
+			for {
+				e.control.wait(e.stop)
+				wait := due - clock.elapsed()
+				if wait <= 0 || e.stopped() {
+					break
+				}
+				select {
+				case <-time.After(min(wait, maxPacingSleep)):
+				case <-e.stop:
+				}
+			}
+		} else if e.control.wait(e.stop); limit > 0 && clock.elapsed() >= limit {
+			break
+		}
+		if e.stopped() {
+			break
+		}
+
+		sent++
+		request := e.captureRequest(recorded.Request)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.sendRequest(request)
+		}()
+	}
+
+	wg.Wait()
+	e.stats.Finish()
+	e.progress.Stop()
+	fmt.Printf("Total time taken: %.2f seconds\n", e.stats.Elapsed().Seconds())
+	fmt.Printf("Requests sent: %d of %d recorded\n", sent, len(rec))
+}
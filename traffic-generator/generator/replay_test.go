@@ -0,0 +1,127 @@
+package generator
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNDJSONRecording(t *testing.T) {
+	data := `
+{"ts":"2024-05-01T10:00:00.500Z","method":"post","path":"/orders?x=1","headers":{"X-Trace":"a","Host":"prod","Content-Length":"9"},"body":{"id":1}}
+{"ts":"2024-05-01T10:00:00Z","method":"GET","url":"https://prod.example.com/health","endpoint":"health"}
+
+{"ts":"2024-05-01T10:00:02Z","method":"PUT","path":"/orders/1","body":"plain text"}
+`
+	rec, err := ParseNDJSONRecording(strings.NewReader(data), "http://staging:8080/api")
+
+	assert.NoError(t, err)
+	if assert.Len(t, rec, 3) {
+		// sorted by time and rebased to the first request
+		assert.Equal(t, time.Duration(0), rec[0].Offset)
+		assert.Equal(t, "health", rec[0].Endpoint)
+		assert.Equal(t, "http://staging:8080/api/health", rec[0].URL)
+
+		assert.Equal(t, 500*time.Millisecond, rec[1].Offset)
+		assert.Equal(t, "POST", rec[1].Method)
+		assert.Equal(t, "/orders", rec[1].Endpoint)
+		assert.Equal(t, "http://staging:8080/api/orders?x=1", rec[1].URL)
+		assert.Equal(t, map[string]string{"X-Trace": "a"}, rec[1].Headers)
+		assert.Equal(t, `{"id":1}`, string(rec[1].Body))
+
+		assert.Equal(t, 2*time.Second, rec[2].Offset)
+		assert.Equal(t, "plain text", string(rec[2].Body))
+	}
+	assert.Equal(t, 2*time.Second, rec.Duration())
+}
+
+func TestParseNDJSONRecording_Errors(t *testing.T) {
+	cases := map[string]string{
+		"line 1: invalid character":        `not json`,
+		"line 2: method and url or path":   "{\"method\":\"GET\",\"url\":\"http://x/\"}\n{\"url\":\"http://x/\"}",
+		"has no host, set a replay target": `{"method":"GET","path":"/orders"}`,
+		"recording has no requests":        "\n\n",
+	}
+
+	for want, data := range cases {
+		_, err := ParseNDJSONRecording(strings.NewReader(data), "")
+		assert.ErrorContains(t, err, want, data)
+	}
+}
+
+func TestParseHAR(t *testing.T) {
+	har := `{"log":{"entries":[
+{"startedDateTime":"2024-05-01T10:00:01.250Z","request":{"method":"POST","url":"https://prod.example.com/login",
+ "headers":[{"name":":authority","value":"prod.example.com"},{"name":"Cookie","value":"s=1"},{"name":"Connection","value":"keep-alive"}],
+ "postData":{"mimeType":"application/x-www-form-urlencoded","text":"user=a"}}},
+{"startedDateTime":"2024-05-01T10:00:00Z","request":{"method":"GET","url":"https://prod.example.com/","headers":[]}}
+]}}`
+
+	rec, err := ParseHAR([]byte(har), "")
+
+	assert.NoError(t, err)
+	if assert.Len(t, rec, 2) {
+		assert.Equal(t, "https://prod.example.com/", rec[0].URL)
+		assert.Equal(t, 1250*time.Millisecond, rec[1].Offset)
+		assert.Equal(t, map[string]string{"Cookie": "s=1", "Content-Type": "application/x-www-form-urlencoded"}, rec[1].Headers)
+		assert.Equal(t, "user=a", string(rec[1].Body))
+	}
+}
+
+func TestLoadRecording_DetectsHAR(t *testing.T) {
+	dir := t.TempDir()
+	har := filepath.Join(dir, "session.har")
+	os.WriteFile(har, []byte(`{"log":{"entries":[{"startedDateTime":"2024-05-01T10:00:00Z","request":{"method":"GET","url":"http://x/"}}]}}`), 0o644)
+
+	rec, err := LoadRecording(har, "")
+	assert.NoError(t, err)
+	assert.Len(t, rec, 1)
+
+	_, err = LoadRecording(filepath.Join(dir, "missing.jsonl"), "")
+	assert.Error(t, err)
+}
+
+func TestRun_ReplayKeepsRequestsAndTiming(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	var arrivals []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("X-Trace")+" "+string(body))
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	rec, err := ParseNDJSONRecording(strings.NewReader(`
+{"offset_ms":0,"method":"GET","path":"/a","headers":{"X-Trace":"1"}}
+{"offset_ms":200,"method":"POST","path":"/b?q=2","body":"{\"n\":2}"}
+{"offset_ms":400,"method":"DELETE","path":"/c"}
+`), server.URL)
+	assert.NoError(t, err)
+
+	// twice as fast: the last request goes out about 200ms in
+	start := time.Now()
+	stats := Run(Options{Mode: "replay", Replay: rec, ReplaySpeed: 2}, nil)
+
+	assert.Equal(t, []string{"GET /a 1 ", `POST /b?q=2  {"n":2}`, "DELETE /c  "}, got)
+	assert.InDelta(t, 200, arrivals[2].Sub(start).Milliseconds(), 60)
+	assert.Equal(t, uint64(3), stats.Overall.Total)
+
+	// as fast as possible, limited by count
+	got = nil
+	start = time.Now()
+	Run(Options{Mode: "replay", Replay: rec, Count: 2}, nil)
+
+	assert.Len(t, got, 2)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
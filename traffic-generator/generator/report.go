@@ -0,0 +1,457 @@
+package generator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Summary is the machine-readable result of a run
+type Summary struct {
+	Start             time.Time         `json:"start"`
+	DurationSeconds   float64           `json:"duration_seconds"`
+	TotalRequests     int64             `json:"total_requests"`
+	Succeeded         int64             `json:"succeeded"`
+	Failed            int64             `json:"failed"`
+	RequestsPerSecond float64           `json:"requests_per_second"`
+	ByMethod          map[string]int64  `json:"by_method"`
+	ByStatus          map[string]int64  `json:"by_status"`
+	Errors            map[string]int64  `json:"errors"`
+	Throughput        []int64           `json:"throughput_per_second"`
+	Latency           []LatencySummary  `json:"latency"`
+	Retries           *RetrySummary     `json:"retries,omitempty"`
+	Schedule          *ScheduleSummary  `json:"schedule,omitempty"`
+	Flows             []FlowSummary     `json:"flows,omitempty"`
+	Thresholds        []ThresholdResult `json:"thresholds,omitempty"`
+}
+
+// RetrySummary describes retried attempts, which are not part of the figures above
+type RetrySummary struct {
+	Attempts  int64            `json:"attempts"`
+	Recovered int64            `json:"recovered"`
+	Exhausted int64            `json:"exhausted"`
+	ByStatus  map[string]int64 `json:"by_status"`
+	Errors    map[string]int64 `json:"errors"`
+	Latency   LatencySummary   `json:"latency"`
+}
+
+// ScheduleSummary tells how well an open mode run kept to its schedule
+type ScheduleSummary struct {
+	Due           int64   `json:"due"`
+	Delayed       int64   `json:"delayed"` // by the in-flight cap
+	Dropped       int64   `json:"dropped"` // by the in-flight cap
+	StartLagP50Ms float64 `json:"start_lag_p50_ms"`
+	StartLagP99Ms float64 `json:"start_lag_p99_ms"`
+	StartLagMaxMs float64 `json:"start_lag_max_ms"`
+}
+
+// FlowSummary counts the runs of one virtual user flow
+type FlowSummary struct {
+	Name      string `json:"name"`
+	Completed int64  `json:"completed"`
+	Aborted   int64  `json:"aborted"` // stopped at a failing step
+}
+
+// LatencySummary holds the latency figures for one endpoint and method, in milliseconds
+type LatencySummary struct {
+	Endpoint    string             `json:"endpoint"`
+	Method      string             `json:"method"`
+	Count       uint64             `json:"count"`
+	MinMs       float64            `json:"min_ms"`
+	MeanMs      float64            `json:"mean_ms"`
+	MaxMs       float64            `json:"max_ms"`
+	Percentiles map[string]float64 `json:"percentiles_ms"`
+}
+
+// percentileName formats 99.9 as "p99.9"
+func percentileName(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func latencySummary(endpoint, method string, h *Histogram) LatencySummary {
+	ls := LatencySummary{
+		Endpoint:    endpoint,
+		Method:      method,
+		Count:       h.Total,
+		MinMs:       millis(h.MinLatency()),
+		MeanMs:      millis(h.Mean()),
+		MaxMs:       millis(h.MaxLatency()),
+		Percentiles: make(map[string]float64, len(reportPercentiles)),
+	}
+	for _, p := range reportPercentiles {
+		ls.Percentiles[percentileName(p)] = millis(h.Percentile(p))
+	}
+	return ls
+}
+
+// Summary builds the run summary from the collected results
+func (s *Stats) Summary() *Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := &Summary{
+		Start:      s.Start,
+		ByMethod:   make(map[string]int64, len(s.Methods)),
+		ByStatus:   make(map[string]int64, len(s.Statuses)),
+		Errors:     make(map[string]int64, len(s.ErrorKind)),
+		Throughput: append([]int64(nil), s.Completed...),
+	}
+
+	end := s.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	sum.DurationSeconds = end.Sub(s.Start).Seconds()
+
+	for method, n := range s.Methods {
+		sum.ByMethod[method] = n
+		sum.TotalRequests += n
+	}
+	for kind, n := range s.ErrorKind {
+		sum.Errors[kind] = n
+	}
+	sum.Failed = s.Errors
+	for status, n := range s.Statuses {
+		sum.ByStatus[strconv.Itoa(status)] = n
+		if status >= 400 {
+			sum.Errors["http_"+strconv.Itoa(status)] += n
+			sum.Failed += n
+		}
+	}
+	sum.Succeeded = sum.TotalRequests - sum.Failed
+	if sum.DurationSeconds > 0 {
+		sum.RequestsPerSecond = float64(sum.TotalRequests) / sum.DurationSeconds
+	}
+
+	for _, k := range s.sortedKeys() {
+		sum.Latency = append(sum.Latency, latencySummary(k.Endpoint, k.Method, s.Latencies[k]))
+	}
+	sum.Latency = append(sum.Latency, latencySummary("all", "", s.Overall))
+
+	if s.Retries > 0 {
+		sum.Retries = &RetrySummary{
+			Attempts:  s.Retries,
+			Recovered: s.Recovered,
+			Exhausted: s.Exhausted,
+			ByStatus:  make(map[string]int64, len(s.RetryStatuses)),
+			Errors:    make(map[string]int64, len(s.RetryErrors)),
+			Latency:   latencySummary("retries", "", s.RetryLatency),
+		}
+		for status, n := range s.RetryStatuses {
+			sum.Retries.ByStatus[strconv.Itoa(status)] = n
+		}
+		for kind, n := range s.RetryErrors {
+			sum.Retries.Errors[kind] = n
+		}
+	}
+
+	if s.Due > 0 {
+		sum.Schedule = &ScheduleSummary{
+			Due:           s.Due,
+			Delayed:       s.Delayed,
+			Dropped:       s.Dropped,
+			StartLagP50Ms: millis(s.StartLag.Percentile(50)),
+			StartLagP99Ms: millis(s.StartLag.Percentile(99)),
+			StartLagMaxMs: millis(s.StartLag.MaxLatency()),
+		}
+	}
+
+	for _, name := range s.flowNames() {
+		sum.Flows = append(sum.Flows, FlowSummary{Name: name, Completed: s.FlowsCompleted[name], Aborted: s.FlowsAborted[name]})
+	}
+
+	return sum
+}
+
+// WriteReport writes the summary to path in the given format ("json", "csv" or "html")
+func WriteReport(path, format string, sum *Summary) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create report file: %w", err)
+	}
+	defer file.Close()
+
+	switch format {
+	case "json":
+		err = writeJSONReport(file, sum)
+	case "csv":
+		err = writeCSVReport(file, sum)
+	case "html":
+		err = writeHTMLReport(file, sum)
+	default:
+		err = fmt.Errorf("unknown report format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write report: %w", err)
+	}
+	return file.Close()
+}
+
+func writeJSONReport(w io.Writer, sum *Summary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sum)
+}
+
+// sortedCounts returns map keys in order so reports diff cleanly between runs
+func sortedCounts(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeCSVReport writes one "section,name,field,value" row per figure
+func writeCSVReport(w io.Writer, sum *Summary) error {
+	cw := csv.NewWriter(w)
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	n := func(v int64) string { return strconv.FormatInt(v, 10) }
+
+	rows := [][]string{
+		{"section", "name", "field", "value"},
+		{"summary", "run", "start", sum.Start.Format(time.RFC3339)},
+		{"summary", "run", "duration_seconds", f(sum.DurationSeconds)},
+		{"summary", "run", "total_requests", n(sum.TotalRequests)},
+		{"summary", "run", "succeeded", n(sum.Succeeded)},
+		{"summary", "run", "failed", n(sum.Failed)},
+		{"summary", "run", "requests_per_second", f(sum.RequestsPerSecond)},
+	}
+	for _, k := range sortedCounts(sum.ByMethod) {
+		rows = append(rows, []string{"method", k, "count", n(sum.ByMethod[k])})
+	}
+	for _, k := range sortedCounts(sum.ByStatus) {
+		rows = append(rows, []string{"status", k, "count", n(sum.ByStatus[k])})
+	}
+	for _, k := range sortedCounts(sum.Errors) {
+		rows = append(rows, []string{"error", k, "count", n(sum.Errors[k])})
+	}
+	for second, c := range sum.Throughput {
+		rows = append(rows, []string{"throughput", strconv.Itoa(second), "requests", n(c)})
+	}
+	for _, l := range sum.Latency {
+		name := strings.TrimSpace(l.Endpoint + " " + l.Method)
+		rows = append(rows,
+			[]string{"latency", name, "count", strconv.FormatUint(l.Count, 10)},
+			[]string{"latency", name, "min_ms", f(l.MinMs)},
+			[]string{"latency", name, "mean_ms", f(l.MeanMs)},
+		)
+		for _, p := range reportPercentiles {
+			rows = append(rows, []string{"latency", name, percentileName(p) + "_ms", f(l.Percentiles[percentileName(p)])})
+		}
+		rows = append(rows, []string{"latency", name, "max_ms", f(l.MaxMs)})
+	}
+
+	if r := sum.Retries; r != nil {
+		rows = append(rows,
+			[]string{"retry", "all", "attempts", n(r.Attempts)},
+			[]string{"retry", "all", "recovered", n(r.Recovered)},
+			[]string{"retry", "all", "exhausted", n(r.Exhausted)},
+		)
+		for _, k := range sortedCounts(r.ByStatus) {
+			rows = append(rows, []string{"retry", "status " + k, "count", n(r.ByStatus[k])})
+		}
+		for _, k := range sortedCounts(r.Errors) {
+			rows = append(rows, []string{"retry", "error " + k, "count", n(r.Errors[k])})
+		}
+		for _, p := range reportPercentiles {
+			rows = append(rows, []string{"retry", "latency", percentileName(p) + "_ms", f(r.Latency.Percentiles[percentileName(p)])})
+		}
+	}
+
+	if sc := sum.Schedule; sc != nil {
+		rows = append(rows,
+			[]string{"schedule", "all", "due", n(sc.Due)},
+			[]string{"schedule", "all", "delayed", n(sc.Delayed)},
+			[]string{"schedule", "all", "dropped", n(sc.Dropped)},
+			[]string{"schedule", "start_lag", "p50_ms", f(sc.StartLagP50Ms)},
+			[]string{"schedule", "start_lag", "p99_ms", f(sc.StartLagP99Ms)},
+			[]string{"schedule", "start_lag", "max_ms", f(sc.StartLagMaxMs)},
+		)
+	}
+
+	for _, fl := range sum.Flows {
+		rows = append(rows,
+			[]string{"flow", fl.Name, "completed", n(fl.Completed)},
+			[]string{"flow", fl.Name, "aborted", n(fl.Aborted)},
+		)
+	}
+
+	for _, t := range sum.Thresholds {
+		rows = append(rows,
+			[]string{"threshold", t.Expr, "actual", f(t.Actual)},
+			[]string{"threshold", t.Expr, "passed", strconv.FormatBool(t.Passed)},
+		)
+	}
+
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// chartPoint is one vertex of an SVG polyline or one bar
+type chartPoint struct {
+	X, Y, W, H float64
+	Label      string
+}
+
+const (
+	chartWidth  = 720.0
+	chartHeight = 200.0
+)
+
+// throughputChart scales the per-second counts into the chart area
+func throughputChart(counts []int64) string {
+	if len(counts) == 0 {
+		return ""
+	}
+	var max int64 = 1
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+	step := chartWidth
+	if len(counts) > 1 {
+		step = chartWidth / float64(len(counts)-1)
+	}
+
+	var b strings.Builder
+	for i, c := range counts {
+		fmt.Fprintf(&b, "%.1f,%.1f ", float64(i)*step, chartHeight-float64(c)/float64(max)*chartHeight)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// percentileBars scales the overall latency percentiles into bars
+func percentileBars(l LatencySummary) []chartPoint {
+	max := l.MaxMs
+	if max <= 0 {
+		max = 1
+	}
+	names := []string{"min"}
+	values := []float64{l.MinMs}
+	for _, p := range reportPercentiles {
+		names = append(names, percentileName(p))
+		values = append(values, l.Percentiles[percentileName(p)])
+	}
+	names = append(names, "max")
+	values = append(values, l.MaxMs)
+
+	width := chartWidth / float64(len(values))
+	bars := make([]chartPoint, len(values))
+	for i, v := range values {
+		h := v / max * chartHeight
+		bars[i] = chartPoint{
+			X:     float64(i)*width + 4,
+			Y:     chartHeight - h,
+			W:     width - 8,
+			H:     h,
+			Label: fmt.Sprintf("%s %.1fms", names[i], v),
+		}
+	}
+	return bars
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pname": percentileName,
+	"pcts":  func() []float64 { return reportPercentiles },
+	"keys":  sortedCounts,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Traffic Generator Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+svg { background: #f7f7f7; margin-bottom: 1.5em; }
+</style>
+</head>
+<body>
+<h1>Traffic Generator Report</h1>
+<p>Started {{.Sum.Start.Format "2006-01-02 15:04:05"}}, ran {{printf "%.2f" .Sum.DurationSeconds}}s,
+{{.Sum.TotalRequests}} requests ({{.Sum.Succeeded}} succeeded, {{.Sum.Failed}} failed),
+{{printf "%.2f" .Sum.RequestsPerSecond}} requests/s.</p>
+
+{{if .Sum.Thresholds}}<h2>Thresholds</h2>
+<table><tr><th>threshold</th><th>actual</th><th>result</th></tr>
+{{range .Sum.Thresholds}}<tr><td>{{.Expr}}</td><td>{{.FormatActual}}</td><td>{{if .Passed}}pass{{else}}<b>fail</b>{{end}}</td></tr>
+{{end}}</table>{{end}}
+
+<h2>Throughput (requests per second)</h2>
+<svg width="720" height="200" viewBox="0 0 720 200"><polyline fill="none" stroke="#2a6fdb" stroke-width="2" points="{{.Throughput}}"/></svg>
+
+<h2>Overall latency</h2>
+<svg width="720" height="220" viewBox="0 0 720 220">
+{{range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{.W}}" height="{{.H}}" fill="#e07b39"/><text x="{{.X}}" y="215" font-size="11">{{.Label}}</text>
+{{end}}</svg>
+
+<h2>Latency by endpoint and method (ms)</h2>
+<table>
+<tr><th>endpoint</th><th>method</th><th>count</th><th>min</th><th>mean</th>{{range pcts}}<th>{{pname .}}</th>{{end}}<th>max</th></tr>
+{{range .Sum.Latency}}{{$l := .}}<tr><td>{{.Endpoint}}</td><td>{{.Method}}</td><td>{{.Count}}</td><td>{{printf "%.2f" .MinMs}}</td><td>{{printf "%.2f" .MeanMs}}</td>{{range pcts}}<td>{{printf "%.2f" (index $l.Percentiles (pname .))}}</td>{{end}}<td>{{printf "%.2f" .MaxMs}}</td></tr>
+{{end}}</table>
+
+<h2>Requests by method</h2>
+<table><tr><th>method</th><th>count</th></tr>
+{{range keys .Sum.ByMethod}}<tr><td>{{.}}</td><td>{{index $.Sum.ByMethod .}}</td></tr>
+{{end}}</table>
+
+<h2>Responses by status</h2>
+<table><tr><th>status</th><th>count</th></tr>
+{{range keys .Sum.ByStatus}}<tr><td>{{.}}</td><td>{{index $.Sum.ByStatus .}}</td></tr>
+{{end}}</table>
+
+{{if .Sum.Errors}}<h2>Errors</h2>
+<table><tr><th>error</th><th>count</th></tr>
+{{range keys .Sum.Errors}}<tr><td>{{.}}</td><td>{{index $.Sum.Errors .}}</td></tr>
+{{end}}</table>{{end}}
+
+{{with .Sum.Retries}}<h2>Retries</h2>
+<p>{{.Attempts}} retry attempts, {{.Recovered}} requests recovered, {{.Exhausted}} still failing after their last attempt.
+Retry latency p50 {{printf "%.2f" (index .Latency.Percentiles "p50")}}ms, p99 {{printf "%.2f" (index .Latency.Percentiles "p99")}}ms.</p>
+<table><tr><th>outcome</th><th>count</th></tr>
+{{range keys .ByStatus}}<tr><td>status {{.}}</td><td>{{index $.Sum.Retries.ByStatus .}}</td></tr>
+{{end}}{{range keys .Errors}}<tr><td>{{.}}</td><td>{{index $.Sum.Retries.Errors .}}</td></tr>
+{{end}}</table>{{end}}
+
+{{with .Sum.Schedule}}<h2>Schedule</h2>
+<p>{{.Due}} requests due, {{.Delayed}} delayed and {{.Dropped}} dropped because the in-flight cap was reached.
+Start lag p50 {{printf "%.2f" .StartLagP50Ms}}ms, p99 {{printf "%.2f" .StartLagP99Ms}}ms, max {{printf "%.2f" .StartLagMaxMs}}ms.</p>{{end}}
+
+{{if .Sum.Flows}}<h2>Flows</h2>
+<table><tr><th>flow</th><th>completed</th><th>aborted</th></tr>
+{{range .Sum.Flows}}<tr><td>{{.Name}}</td><td>{{.Completed}}</td><td>{{.Aborted}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+func writeHTMLReport(w io.Writer, sum *Summary) error {
+	var overall LatencySummary
+	if len(sum.Latency) > 0 {
+		overall = sum.Latency[len(sum.Latency)-1]
+	}
+	return htmlReport.Execute(w, struct {
+		Sum        *Summary
+		Throughput string
+		Bars       []chartPoint
+	}{sum, throughputChart(sum.Throughput), percentileBars(overall)})
+}
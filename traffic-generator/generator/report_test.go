@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleStats() *Stats {
+	s := NewStats()
+	s.Record(&Result{Endpoint: "collect", Method: "POST", StatusCode: 200, Latency: 3 * time.Millisecond}, nil)
+	s.Record(&Result{Endpoint: "collect", Method: "GET", StatusCode: 200, Latency: time.Millisecond}, nil)
+	s.Record(&Result{Endpoint: "collect", Method: "GET", StatusCode: 503, Latency: time.Millisecond}, nil)
+	s.Record(&Result{Endpoint: "collect", Method: "PUT"}, syscall.ECONNREFUSED)
+	s.Finish()
+	return s
+}
+
+func TestStats_Summary(t *testing.T) {
+	sum := sampleStats().Summary()
+
+	assert.Equal(t, int64(4), sum.TotalRequests)
+	assert.Equal(t, int64(2), sum.Succeeded)
+	assert.Equal(t, int64(2), sum.Failed)
+	assert.Equal(t, map[string]int64{"GET": 2, "POST": 1, "PUT": 1}, sum.ByMethod)
+	assert.Equal(t, map[string]int64{"200": 2, "503": 1}, sum.ByStatus)
+	assert.Equal(t, map[string]int64{"connection_refused": 1, "http_503": 1}, sum.Errors)
+	assert.Equal(t, []int64{4}, sum.Throughput)
+	if assert.Len(t, sum.Latency, 3) {
+		assert.Equal(t, "all", sum.Latency[2].Endpoint)
+		assert.Equal(t, uint64(3), sum.Latency[2].Count)
+		assert.Contains(t, sum.Latency[2].Percentiles, "p99.9")
+	}
+}
+
+func TestStats_ElapsedWhileFinishing(t *testing.T) {
+	stats := NewStats()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			stats.Elapsed()
+		}
+	}()
+	stats.Finish()
+	<-done
+
+	assert.Equal(t, stats.End.Sub(stats.Start), stats.Elapsed())
+}
+
+func TestWriteReport_AllFormats(t *testing.T) {
+	dir := t.TempDir()
+	sum := sampleStats().Summary()
+
+	jsonPath := filepath.Join(dir, "report.json")
+	assert.NoError(t, WriteReport(jsonPath, "json", sum))
+	data, _ := os.ReadFile(jsonPath)
+	var decoded Summary
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, sum.TotalRequests, decoded.TotalRequests)
+
+	csvPath := filepath.Join(dir, "report.csv")
+	assert.NoError(t, WriteReport(csvPath, "csv", sum))
+	data, _ = os.ReadFile(csvPath)
+	assert.True(t, strings.HasPrefix(string(data), "section,name,field,value\n"))
+	assert.Contains(t, string(data), "status,503,count,1\n")
+	assert.Contains(t, string(data), "latency,collect POST,p99_ms,")
+
+	htmlPath := filepath.Join(dir, "report.html")
+	assert.NoError(t, WriteReport(htmlPath, "html", sum))
+	data, _ = os.ReadFile(htmlPath)
+	assert.Contains(t, string(data), "<svg")
+	assert.Contains(t, string(data), "connection_refused")
+
+	assert.Error(t, WriteReport(filepath.Join(dir, "report.xml"), "xml", sum))
+}
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, "connection_refused", classifyError(syscall.ECONNREFUSED))
+	assert.Equal(t, "other", classifyError(errors.New("boom")))
+}
@@ -1,11 +1,8 @@
 package generator
 
 import (
-	"bytes"
-	"fmt"
-	"math/rand"
 	"net/http"
-	"os"
+	"time"
 )
 
 // APIRequest interface
@@ -21,80 +18,61 @@ type DeleteRequest struct{}
 
 // Implement SendRequest for each request type
 func (g GetRequest) SendRequest(url string) error {
-	return sendHTTPRequest("GET", url, nil)
+	_, err := sendHTTPRequest(&Request{Method: "GET", URL: url})
+	return err
 }
 
 func (p PostRequest) SendRequest(url string) error {
 	payload := RandomData()
-	return sendHTTPRequest("POST", url, payload)
+	_, err := sendHTTPRequest(&Request{Method: "POST", URL: url, Body: payload})
+	return err
 }
 
 func (p PutRequest) SendRequest(url string) error {
 	payload := RandomData()
-	return sendHTTPRequest("PUT", url, payload)
+	_, err := sendHTTPRequest(&Request{Method: "PUT", URL: url, Body: payload})
+	return err
 }
 
 func (d DeleteRequest) SendRequest(url string) error {
-	return sendHTTPRequest("DELETE", url, nil)
+	_, err := sendHTTPRequest(&Request{Method: "DELETE", URL: url})
+	return err
 }
 
-// Function to send HTTP requests and log details
-func sendHTTPRequest(method, url string, body []byte) error {
-	client := &http.Client{}
-	var req *http.Request
-	var err error
-
-	// Capture request body size
-	bodySize := len(body)
-
-	if method == "POST" || method == "PUT" {
-		req, err = http.NewRequest(method, url, bytes.NewBuffer(body))
-		req.Header.Set("Content-Type", "application/json")
-	} else {
-		req, err = http.NewRequest(method, url, nil)
-	}
-
-	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error sending request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Capture response status
-	statusCode := resp.StatusCode
-
-	// Prepare log entry
-	logEntry := fmt.Sprintf("[Request] Method: %s, URL: %s, Body Size: %d bytes\n[Response] Status: %d\n",
-		method, url, bodySize, statusCode)
-
-	// Write log entry to file
-	err = writeLog(logEntry)
-	if err != nil {
-		fmt.Println("Error writing to log file:", err)
-	}
-
-	return nil
+// Result describes the outcome of one request
+type Result struct {
+	Endpoint        string
+	Method          string
+	URL             string
+	StatusCode      int
+	Latency         time.Duration // from sending until the response body was read
+	TimeToFirstByte time.Duration
+	ConnectTime     time.Duration // zero when a pooled connection was reused
+	TLSTime         time.Duration
+	ConnReused      bool
+	BytesSent       int64
+	BytesReceived   int64
+	RetryAfter      time.Duration // from a Retry-After response header
+	Attempt         int           // 1 for the first attempt, higher for retries
+
+	Header http.Header // response headers, only when the request asked to keep the response
+	Body   []byte      // response body up to 1MB, only when the request asked to keep the response
+
+	sentHeader http.Header // request headers as sent, auth included; nil when it wasn't
 }
 
-// Function to write logs to log.txt
-func writeLog(entry string) error {
-	file, err := os.OpenFile("log.txt", os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+// Send sends a materialized request with the shared default client
+func (r *Request) Send() (*Result, error) {
+	return DefaultClient.Do(r)
+}
 
-	_, err = file.WriteString(entry)
-	return err
+// Function to send HTTP requests through the shared default client
+func sendHTTPRequest(r *Request) (*Result, error) {
+	return DefaultClient.Do(r)
 }
 
 // Function to get a random API request type
 func GetRandomRequest() APIRequest {
 	requests := []APIRequest{GetRequest{}, PostRequest{}, PutRequest{}, DeleteRequest{}}
-	return requests[rand.Intn(len(requests))]
+	return requests[rng.Intn(len(requests))]
 }
@@ -0,0 +1,221 @@
+package generator
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"traffic-generator/spec"
+)
+
+// DefaultResultLogPath is where results are logged when no path is configured,
+// with ".gz" added when the log is gzipped
+const DefaultResultLogPath = "results.ndjson"
+
+// LogRecord is one line of the NDJSON result log
+type LogRecord struct {
+	Time          time.Time `json:"ts"`
+	Endpoint      string    `json:"endpoint"`
+	Method        string    `json:"method"`
+	URL           string    `json:"url"`
+	Status        int       `json:"status,omitempty"`
+	LatencyMs     float64   `json:"latency_ms"`
+	TTFBMs        float64   `json:"ttfb_ms"`
+	ConnectMs     float64   `json:"connect_ms"`
+	TLSMs         float64   `json:"tls_ms,omitempty"`
+	ConnReused    bool      `json:"conn_reused"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+	Attempt       int       `json:"attempt,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// ResultLog writes records from many goroutines through a single writer goroutine,
+// so lines never interleave and the file is opened once per rotation, not per request
+type ResultLog struct {
+	opts    spec.ResultLogOptions
+	records chan interface{} // LogRecord, or CaptureRecord when written by a Capture
+	done    chan error
+
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	written int64
+}
+
+// OpenResultLog truncates (or creates) the log file and starts the writer goroutine
+func OpenResultLog(opts spec.ResultLogOptions) (*ResultLog, error) {
+	if opts.Path == "" {
+		opts.Path = DefaultResultLogPath
+		if opts.Gzip {
+			opts.Path += ".gz"
+		}
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = 5
+	}
+
+	l := &ResultLog{
+		opts:    opts,
+		records: make(chan interface{}, 4096),
+		done:    make(chan error, 1),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	go l.run()
+	return l, nil
+}
+
+// Write queues a record; it blocks only if the writer falls far behind
+func (l *ResultLog) Write(rec LogRecord) {
+	l.records <- rec
+}
+
+// WriteResult queues the record for one finished request
+func (l *ResultLog) WriteResult(result *Result, err error) {
+	rec := LogRecord{
+		Time:          time.Now().UTC(),
+		Endpoint:      result.Endpoint,
+		Method:        result.Method,
+		URL:           result.URL,
+		Status:        result.StatusCode,
+		LatencyMs:     millis(result.Latency),
+		TTFBMs:        millis(result.TimeToFirstByte),
+		ConnectMs:     millis(result.ConnectTime),
+		TLSMs:         millis(result.TLSTime),
+		ConnReused:    result.ConnReused,
+		BytesSent:     result.BytesSent,
+		BytesReceived: result.BytesReceived,
+		Attempt:       result.Attempt,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	l.Write(rec)
+}
+
+// Close flushes pending records and closes the file
+func (l *ResultLog) Close() error {
+	close(l.records)
+	return <-l.done
+}
+
+func (l *ResultLog) run() {
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+			fmt.Println("Error writing result log:", err)
+		}
+	}
+
+	for {
+		select {
+		case rec, ok := <-l.records:
+			if !ok {
+				keep(l.close())
+				l.done <- firstErr
+				return
+			}
+			keep(l.write(rec))
+		case <-flush.C:
+			keep(l.flush())
+		}
+	}
+}
+
+func (l *ResultLog) write(rec interface{}) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.opts.MaxSize > 0 && l.written > 0 && l.written+int64(len(line)) > l.opts.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.buf.Write(line)
+	l.written += int64(n)
+	return err
+}
+
+func (l *ResultLog) open() error {
+	file, err := os.Create(l.opts.Path)
+	if err != nil {
+		return fmt.Errorf("unable to open result log: %w", err)
+	}
+	l.file = file
+	l.written = 0
+
+	var w io.Writer = file
+	if l.opts.Gzip {
+		l.gz = gzip.NewWriter(file)
+		w = l.gz
+	}
+	l.buf = bufio.NewWriterSize(w, 64*1024)
+	return nil
+}
+
+func (l *ResultLog) flush() error {
+	if err := l.buf.Flush(); err != nil {
+		return err
+	}
+	if l.gz != nil {
+		return l.gz.Flush()
+	}
+	return nil
+}
+
+func (l *ResultLog) close() error {
+	err := l.buf.Flush()
+	if l.gz != nil {
+		if gzErr := l.gz.Close(); err == nil {
+			err = gzErr
+		}
+		l.gz = nil
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RotatedName returns the name of the n-th rotated file: results.ndjson.1
+// or, for gzip logs, results.ndjson.1.gz
+func RotatedName(path string, n int) string {
+	if strings.HasSuffix(path, ".gz") {
+		return strings.TrimSuffix(path, ".gz") + "." + strconv.Itoa(n) + ".gz"
+	}
+	return path + "." + strconv.Itoa(n)
+}
+
+// rotate shifts path.1 .. path.N-1 up by one, moves the current file to path.1 and starts a new one
+func (l *ResultLog) rotate() error {
+	if err := l.close(); err != nil {
+		return err
+	}
+
+	os.Remove(RotatedName(l.opts.Path, l.opts.MaxFiles))
+	for n := l.opts.MaxFiles - 1; n >= 1; n-- {
+		os.Rename(RotatedName(l.opts.Path, n), RotatedName(l.opts.Path, n+1))
+	}
+	if err := os.Rename(l.opts.Path, RotatedName(l.opts.Path, 1)); err != nil {
+		return fmt.Errorf("unable to rotate result log: %w", err)
+	}
+
+	return l.open()
+}
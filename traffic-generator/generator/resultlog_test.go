@@ -0,0 +1,111 @@
+package generator
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
+)
+
+// readRecords decodes every NDJSON line of a (possibly gzipped) log file
+func readRecords(t *testing.T, path string, gz bool) []LogRecord {
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer file.Close()
+
+	var scanner *bufio.Scanner
+	if gz {
+		r, err := gzip.NewReader(file)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		scanner = bufio.NewScanner(r)
+	} else {
+		scanner = bufio.NewScanner(file)
+	}
+
+	var records []LogRecord
+	for scanner.Scan() {
+		var rec LogRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &rec), scanner.Text())
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestResultLog_ConcurrentWritesStayParseable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.ndjson")
+	l, err := OpenResultLog(spec.ResultLogOptions{Path: path})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				l.WriteResult(&Result{Method: "POST", URL: "http://x", StatusCode: 200, Latency: time.Millisecond, BytesSent: 42}, nil)
+			}
+		}()
+	}
+	wg.Wait()
+	l.WriteResult(&Result{Method: "GET", URL: "http://x"}, errors.New("connection refused"))
+	assert.NoError(t, l.Close())
+
+	records := readRecords(t, path, false)
+	assert.Len(t, records, 1001)
+	assert.Equal(t, int64(42), records[0].BytesSent)
+	assert.Equal(t, 1.0, records[0].LatencyMs)
+	assert.Equal(t, "connection refused", records[1000].Error)
+}
+
+func TestResultLog_RotatesAndGzips(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.ndjson.gz")
+	l, err := OpenResultLog(spec.ResultLogOptions{Path: path, MaxSize: 1024, MaxFiles: 2, Gzip: true})
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		l.WriteResult(&Result{Method: "GET", URL: "http://localhost:8080/collect", StatusCode: 200}, nil)
+	}
+	assert.NoError(t, l.Close())
+
+	current := readRecords(t, path, true)
+	first := readRecords(t, RotatedName(path, 1), true)
+	second := readRecords(t, RotatedName(path, 2), true)
+	assert.NotEmpty(t, current)
+	assert.NotEmpty(t, first)
+	assert.NotEmpty(t, second)
+	assert.NoFileExists(t, RotatedName(path, 3))
+	assert.Less(t, len(current)+len(first)+len(second), 100)
+}
+
+func TestRotatedName(t *testing.T) {
+	assert.Equal(t, "results.ndjson.1", RotatedName("results.ndjson", 1))
+	assert.Equal(t, "results.ndjson.2.gz", RotatedName("results.ndjson.gz", 2))
+}
+
+func TestResultLog_DefaultPathNamesGzip(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	l, err := OpenResultLog(spec.ResultLogOptions{Gzip: true})
+	assert.NoError(t, err)
+	l.WriteResult(&Result{Method: "GET", URL: "http://localhost:8080/collect", StatusCode: 200}, nil)
+	assert.NoError(t, l.Close())
+
+	assert.Len(t, readRecords(t, DefaultResultLogPath+".gz", true), 1)
+	assert.NoFileExists(t, DefaultResultLogPath)
+}
@@ -0,0 +1,61 @@
+package generator
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"traffic-generator/spec"
+)
+
+// shouldRetry reports whether p retries the outcome of an attempt; a nil p never does
+func shouldRetry(p *spec.RetryPolicy, result *Result, err error) bool {
+	if p == nil || result.Attempt >= p.MaxAttempts {
+		return false
+	}
+	if err != nil {
+		return p.ErrorClasses["all"] || p.ErrorClasses[classifyError(err)]
+	}
+	return p.Statuses[result.StatusCode]
+}
+
+// backoff returns the wait p asks for before the attempt following result
+func backoff(p *spec.RetryPolicy, result *Result) time.Duration {
+	if p.HonorRetryAfter && result.RetryAfter > 0 &&
+		(result.StatusCode == http.StatusTooManyRequests || result.StatusCode == http.StatusServiceUnavailable) &&
+		(p.MaxRetryAfter <= 0 || result.RetryAfter <= p.MaxRetryAfter) {
+		return result.RetryAfter
+	}
+
+	// Without a cap the backoff doubles until it would overflow
+	limit := p.MaxBackoff
+	if limit <= 0 {
+		limit = math.MaxInt64 / 2
+	}
+	backoff := p.InitialBackoff
+	for i := 1; i < result.Attempt && backoff < limit; i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	// keep (1-Jitter) of the backoff and randomize the rest
+	random := time.Duration(float64(backoff) * p.Jitter * retryRng.Float64())
+	return time.Duration(float64(backoff)*(1-p.Jitter)) + random
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
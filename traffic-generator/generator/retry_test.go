@@ -0,0 +1,132 @@
+package generator
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
+)
+
+func testPolicy() *spec.RetryPolicy {
+	return &spec.RetryPolicy{
+		MaxAttempts:     3,
+		InitialBackoff:  100 * time.Millisecond,
+		MaxBackoff:      time.Second,
+		Statuses:        spec.DefaultRetryStatuses,
+		ErrorClasses:    spec.DefaultRetryErrors,
+		HonorRetryAfter: true,
+		MaxRetryAfter:   time.Minute,
+	}
+}
+
+func TestRetryPolicy_ShouldRetry(t *testing.T) {
+	p := testPolicy()
+
+	assert.True(t, shouldRetry(p, &Result{Attempt: 1, StatusCode: 503}, nil))
+	assert.False(t, shouldRetry(p, &Result{Attempt: 1, StatusCode: 404}, nil))
+	assert.True(t, shouldRetry(p, &Result{Attempt: 2}, syscall.ECONNREFUSED))
+	assert.False(t, shouldRetry(p, &Result{Attempt: 1}, errors.New("boom")))
+	assert.False(t, shouldRetry(p, &Result{Attempt: 3, StatusCode: 503}, nil))
+
+	var none *spec.RetryPolicy
+	assert.False(t, shouldRetry(none, &Result{Attempt: 1, StatusCode: 503}, nil))
+}
+
+func TestRetryPolicy_ExponentialBackoff(t *testing.T) {
+	p := testPolicy()
+
+	assert.Equal(t, 100*time.Millisecond, backoff(p, &Result{Attempt: 1}))
+	assert.Equal(t, 200*time.Millisecond, backoff(p, &Result{Attempt: 2}))
+	assert.Equal(t, 400*time.Millisecond, backoff(p, &Result{Attempt: 3}))
+	assert.Equal(t, time.Second, backoff(p, &Result{Attempt: 10}))
+
+	// A zero cap leaves the backoff growing
+	p.MaxBackoff = 0
+	assert.Equal(t, 400*time.Millisecond, backoff(p, &Result{Attempt: 3}))
+	assert.Equal(t, 51200*time.Millisecond, backoff(p, &Result{Attempt: 10}))
+	assert.Positive(t, backoff(p, &Result{Attempt: 100}))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		b := backoff(p, &Result{Attempt: 2})
+		assert.True(t, b >= 100*time.Millisecond && b <= 200*time.Millisecond, "backoff %v", b)
+	}
+}
+
+func TestRetryPolicy_HonorsRetryAfter(t *testing.T) {
+	p := testPolicy()
+
+	assert.Equal(t, 3*time.Second, backoff(p, &Result{Attempt: 1, StatusCode: 429, RetryAfter: 3 * time.Second}))
+	// only 429 and 503 carry a meaningful Retry-After
+	assert.Equal(t, 100*time.Millisecond, backoff(p, &Result{Attempt: 1, StatusCode: 502, RetryAfter: 3 * time.Second}))
+	// unreasonably long waits fall back to the backoff
+	assert.Equal(t, 100*time.Millisecond, backoff(p, &Result{Attempt: 1, StatusCode: 503, RetryAfter: time.Hour}))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Wed, 01 Jan 2025 12:00:30 GMT", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+	assert.Zero(t, parseRetryAfter("", now))
+}
+
+func TestRun_RetriesAreReportedSeparately(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every odd call is rate limited
+		if atomic.AddInt64(&calls, 1)%2 == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	policy := testPolicy()
+	policy.InitialBackoff = time.Millisecond
+	stats := Run(Options{Mode: "closed", Count: 5, VirtualUsers: 1, Retry: policy}, DefaultScenario(server.URL))
+
+	sum := stats.Summary()
+	assert.Equal(t, int64(5), sum.TotalRequests)
+	assert.Equal(t, int64(5), sum.ByStatus["429"])
+	if assert.NotNil(t, sum.Retries) {
+		assert.Equal(t, int64(5), sum.Retries.Attempts)
+		assert.Equal(t, int64(5), sum.Retries.Recovered)
+		assert.Equal(t, int64(5), sum.Retries.ByStatus["200"])
+	}
+}
+
+func TestRun_StopInterruptsBackoff(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	control := NewControl()
+	done := make(chan *Stats)
+	go func() {
+		done <- Run(Options{Mode: "closed", Count: 1, VirtualUsers: 1, Retry: testPolicy(), Control: control}, DefaultScenario(server.URL))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	control.Stop()
+	select {
+	case stats := <-done:
+		// The request ends with its first attempt instead of waiting out the Retry-After
+		assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+		assert.Equal(t, int64(1), stats.Summary().TotalRequests)
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not stop during the retry backoff")
+	}
+}
@@ -0,0 +1,394 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"traffic-generator/spec"
+)
+
+// Scenario describes the mix of endpoints the generator sends traffic to
+type Scenario struct {
+	Headers   map[string]string `yaml:"headers"` // sent with every endpoint unless overridden
+	Auth      *AuthConfig       `yaml:"auth"`    // default auth for endpoints without their own
+	Size      string            `yaml:"size"`    // default body size distribution, see spec.ParseSizeDistribution
+	Endpoints []*Endpoint       `yaml:"endpoints"`
+	Flows     []*Flow           `yaml:"flows"` // scripted request sequences for virtual users, see Flow
+
+	totalWeight     int
+	flowWeights     []int
+	totalFlowWeight int
+}
+
+// Endpoint is a single target in a scenario with its own method mix
+type Endpoint struct {
+	Name     string            `yaml:"name"`
+	URL      string            `yaml:"url"`
+	Weight   int               `yaml:"weight"`
+	Methods  map[string]int    `yaml:"methods"`
+	Headers  map[string]string `yaml:"headers"`
+	Payload  string            `yaml:"payload"`  // "random", "none", "static" or "template"
+	Body     string            `yaml:"body"`     // used when Payload is "static"
+	Template interface{}       `yaml:"template"` // used when Payload is "template", see PayloadTemplate
+	Size     string            `yaml:"size"`     // bodies are padded to a size drawn from this distribution
+	Auth     *AuthConfig       `yaml:"auth"`
+
+	auth          AuthProvider
+	template      *PayloadTemplate
+	size          spec.SizeDistribution
+	methodNames   []string
+	methodWeights []int
+	totalWeight   int
+}
+
+// Request is a fully materialized HTTP request ready to be sent
+type Request struct {
+	Endpoint string
+	Method   string
+	URL      string
+	Headers  map[string]string
+	Body     []byte
+	Auth     AuthProvider
+
+	KeepResponse bool // keep the response headers and body in the result, for flow extraction
+
+	captured *CaptureRecord // written to the run's capture once the first attempt is sent
+}
+
+var validMethods = map[string]bool{"GET": true, "POST": true, "PUT": true, "DELETE": true, "PATCH": true, "HEAD": true}
+
+// DefaultScenario reproduces the original behaviour: one endpoint, four methods with equal odds
+func DefaultScenario(url string) *Scenario {
+	s := &Scenario{Endpoints: []*Endpoint{{
+		Name:    "default",
+		URL:     url,
+		Methods: map[string]int{"GET": 1, "POST": 1, "PUT": 1, "DELETE": 1},
+	}}}
+	s.prepare()
+	return s
+}
+
+// LoadScenario reads a scenario file. An empty path returns the default scenario for baseURL.
+func LoadScenario(path, baseURL string) (*Scenario, error) {
+	if path == "" {
+		return DefaultScenario(baseURL), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read scenario file: %w", err)
+	}
+
+	return ParseScenario(data, baseURL)
+}
+
+// ParseScenario parses scenario YAML and expands {base} in endpoint URLs to baseURL
+func ParseScenario(data []byte, baseURL string) (*Scenario, error) {
+	return parseScenario(data, baseURL, os.Getenv)
+}
+
+// ParseScenarioEnv is ParseScenario with the ${NAME} references of auth
+// settings looked up in env instead of the process environment
+func ParseScenarioEnv(data []byte, baseURL string, env map[string]string) (*Scenario, error) {
+	return parseScenario(data, baseURL, func(name string) string { return env[name] })
+}
+
+func parseScenario(data []byte, baseURL string, getenv func(string) string) (*Scenario, error) {
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("unable to parse scenario file: %w", err)
+	}
+	for _, c := range s.authConfigs() {
+		c.getenv = getenv
+	}
+
+	if len(s.Endpoints) == 0 && len(s.Flows) == 0 {
+		return nil, fmt.Errorf("scenario has no endpoints or flows")
+	}
+	// Flows only run in closed mode, where they replace the endpoints entirely
+	if len(s.Endpoints) > 0 && len(s.Flows) > 0 {
+		return nil, fmt.Errorf("scenario has both endpoints and flows; flows replace the endpoints, so use one or the other")
+	}
+
+	// One scenario-level provider is shared so endpoints share its token cache
+	var defaultAuth AuthProvider
+	if s.Auth != nil {
+		var err error
+		defaultAuth, err = NewAuthProvider(s.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("scenario auth: %w", err)
+		}
+	}
+
+	var defaultSize spec.SizeDistribution
+	if s.Size != "" {
+		var err error
+		defaultSize, err = spec.ParseSizeDistribution(s.Size)
+		if err != nil {
+			return nil, fmt.Errorf("scenario size: %w", err)
+		}
+	}
+
+	for i, ep := range s.Endpoints {
+		if ep.Name == "" {
+			ep.Name = fmt.Sprintf("endpoint-%d", i+1)
+		}
+		if err := prepareEndpoint(ep, baseURL, s.Headers, defaultAuth, defaultSize); err != nil {
+			return nil, err
+		}
+	}
+	for i, flow := range s.Flows {
+		if err := flow.prepare(i, baseURL, s.Headers, defaultAuth, defaultSize); err != nil {
+			return nil, err
+		}
+	}
+
+	s.prepare()
+	for _, ep := range s.Endpoints {
+		if ep.totalWeight == 0 {
+			return nil, fmt.Errorf("endpoint %q: all method weights are zero", ep.Name)
+		}
+	}
+
+	return &s, nil
+}
+
+// authConfigs returns every auth section of the scenario, its endpoints and flow steps
+func (s *Scenario) authConfigs() []*AuthConfig {
+	var configs []*AuthConfig
+	add := func(c *AuthConfig) {
+		if c != nil {
+			configs = append(configs, c)
+		}
+	}
+	add(s.Auth)
+	for _, ep := range s.Endpoints {
+		add(ep.Auth)
+	}
+	for _, flow := range s.Flows {
+		for _, step := range flow.Steps {
+			add(step.Auth)
+		}
+	}
+	return configs
+}
+
+// prepareEndpoint checks ep and fills in the scenario defaults: headers, auth and body size
+func prepareEndpoint(ep *Endpoint, baseURL string, defaultHeaders map[string]string, defaultAuth AuthProvider, defaultSize spec.SizeDistribution) error {
+	if ep.URL == "" {
+		return fmt.Errorf("endpoint %q: url not set", ep.Name)
+	}
+	if strings.Contains(ep.URL, "{base}") && baseURL == "" {
+		return fmt.Errorf("endpoint %q: url uses {base} but COLLECTOR_URL not set", ep.Name)
+	}
+	ep.URL = strings.ReplaceAll(ep.URL, "{base}", baseURL)
+
+	if ep.Weight < 0 {
+		return fmt.Errorf("endpoint %q: weight must not be negative", ep.Name)
+	}
+	if ep.Weight == 0 {
+		ep.Weight = 1
+	}
+
+	if len(ep.Methods) == 0 {
+		ep.Methods = map[string]int{"GET": 1}
+	}
+	normalized := make(map[string]int, len(ep.Methods))
+	for method, weight := range ep.Methods {
+		method = strings.ToUpper(method)
+		if !validMethods[method] {
+			return fmt.Errorf("endpoint %q: unsupported method %q", ep.Name, method)
+		}
+		if weight < 0 {
+			return fmt.Errorf("endpoint %q: weight for %s must not be negative", ep.Name, method)
+		}
+		normalized[method] = weight
+	}
+	ep.Methods = normalized
+
+	headers := make(map[string]string, len(defaultHeaders)+len(ep.Headers))
+	for k, v := range defaultHeaders {
+		headers[k] = v
+	}
+	for k, v := range ep.Headers {
+		headers[k] = v
+	}
+	ep.Headers = headers
+
+	ep.auth = defaultAuth
+	if ep.Auth != nil {
+		provider, err := NewAuthProvider(ep.Auth)
+		if err != nil {
+			return fmt.Errorf("endpoint %q auth: %w", ep.Name, err)
+		}
+		ep.auth = provider
+	}
+
+	ep.size = defaultSize
+	if ep.Size != "" {
+		size, err := spec.ParseSizeDistribution(ep.Size)
+		if err != nil {
+			return fmt.Errorf("endpoint %q size: %w", ep.Name, err)
+		}
+		ep.size = size
+	}
+
+	switch ep.Payload {
+	case "", "random", "none":
+	case "static":
+		if ep.Body == "" {
+			return fmt.Errorf("endpoint %q: static payload needs a body", ep.Name)
+		}
+	case "template":
+		if ep.Template == nil {
+			return fmt.Errorf("endpoint %q: template payload needs a template", ep.Name)
+		}
+		template, err := ParsePayloadTemplate(ep.Template)
+		if err != nil {
+			return fmt.Errorf("endpoint %q: %w", ep.Name, err)
+		}
+		ep.template = template
+	default:
+		return fmt.Errorf("endpoint %q: unknown payload generator %q", ep.Name, ep.Payload)
+	}
+	return nil
+}
+
+// prepare caches the weight tables in a stable order so picks are reproducible
+func (s *Scenario) prepare() {
+	s.totalWeight = 0
+	for _, ep := range s.Endpoints {
+		s.totalWeight += ep.Weight
+
+		ep.methodNames = ep.methodNames[:0]
+		for method := range ep.Methods {
+			ep.methodNames = append(ep.methodNames, method)
+		}
+		sort.Strings(ep.methodNames)
+
+		ep.methodWeights = ep.methodWeights[:0]
+		ep.totalWeight = 0
+		for _, method := range ep.methodNames {
+			ep.methodWeights = append(ep.methodWeights, ep.Methods[method])
+			ep.totalWeight += ep.Methods[method]
+		}
+	}
+	if s.totalWeight == 0 && len(s.Endpoints) > 0 {
+		for _, ep := range s.Endpoints {
+			ep.Weight = 1
+		}
+		s.totalWeight = len(s.Endpoints)
+	}
+
+	s.flowWeights = s.flowWeights[:0]
+	s.totalFlowWeight = 0
+	for _, flow := range s.Flows {
+		s.flowWeights = append(s.flowWeights, flow.Weight)
+		s.totalFlowWeight += flow.Weight
+	}
+}
+
+// SetPayloadSize applies a body size distribution to every endpoint that has none of its own
+func (s *Scenario) SetPayloadSize(size spec.SizeDistribution) {
+	for _, ep := range s.Endpoints {
+		if ep.size == nil {
+			ep.size = size
+		}
+	}
+	for _, flow := range s.Flows {
+		for _, step := range flow.Steps {
+			if step.size == nil {
+				step.size = size
+			}
+		}
+	}
+}
+
+// pickWeighted returns an index into weights chosen proportionally to its weight
+func pickWeighted(weights []int, total int) int {
+	return pickWeightedWith(rng, weights, total)
+}
+
+// pickWeightedWith is pickWeighted drawing from r
+func pickWeightedWith(r *rand.Rand, weights []int, total int) int {
+	n := r.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(weights) - 1
+}
+
+// PickEndpoint chooses an endpoint using the configured endpoint weights
+func (s *Scenario) PickEndpoint() *Endpoint {
+	if len(s.Endpoints) == 1 {
+		return s.Endpoints[0]
+	}
+	weights := make([]int, len(s.Endpoints))
+	for i, ep := range s.Endpoints {
+		weights[i] = ep.Weight
+	}
+	return s.Endpoints[pickWeighted(weights, s.totalWeight)]
+}
+
+// PickMethod chooses an HTTP method using the endpoint's method weights
+func (ep *Endpoint) PickMethod() string {
+	return ep.methodNames[pickWeighted(ep.methodWeights, ep.totalWeight)]
+}
+
+// NextRequest picks an endpoint and method and builds the request to send
+func (s *Scenario) NextRequest() *Request {
+	ep := s.PickEndpoint()
+	return ep.BuildRequest(ep.PickMethod())
+}
+
+// BuildRequest materializes a request for the given method on this endpoint
+func (ep *Endpoint) BuildRequest(method string) *Request {
+	return ep.pad(ep.build(method))
+}
+
+// build materializes the request without padding its body
+func (ep *Endpoint) build(method string) *Request {
+	req := &Request{
+		Endpoint: ep.Name,
+		Method:   method,
+		URL:      strings.ReplaceAll(ep.URL, "{rand_int}", strconv.Itoa(rng.Intn(1000))),
+		Headers:  make(map[string]string, len(ep.Headers)),
+		Auth:     ep.auth,
+	}
+	for k, v := range ep.Headers {
+		req.Headers[k] = v
+	}
+
+	switch ep.Payload {
+	case "none":
+	case "static":
+		req.Body = []byte(ep.Body)
+	case "random":
+		req.Body = RandomData()
+	case "template":
+		req.Body = ep.template.Render(rng)
+	default:
+		if method == "POST" || method == "PUT" {
+			req.Body = RandomData()
+		}
+	}
+
+	return req
+}
+
+// pad grows the request body to a size drawn from the endpoint's distribution
+func (ep *Endpoint) pad(req *Request) *Request {
+	if ep.size != nil && req.Body != nil {
+		req.Body = PadJSON(req.Body, ep.size.Sample(rng))
+	}
+	return req
+}
@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
+)
+
+func TestParseScenario_ValidFile(t *testing.T) {
+	data := []byte(`
+endpoints:
+  - name: collect
+    url: "{base}/collect"
+    weight: 3
+    methods:
+      post: 2
+      GET: 1
+    headers:
+      X-Test: "yes"
+  - url: "http://example.com/health"
+    payload: none
+`)
+
+	s, err := ParseScenario(data, "http://localhost:8080")
+
+	assert.NoError(t, err)
+	assert.Len(t, s.Endpoints, 2)
+	assert.Equal(t, "http://localhost:8080/collect", s.Endpoints[0].URL)
+	assert.Equal(t, map[string]int{"POST": 2, "GET": 1}, s.Endpoints[0].Methods)
+	assert.Equal(t, "endpoint-2", s.Endpoints[1].Name)
+	assert.Equal(t, 1, s.Endpoints[1].Weight)
+}
+
+func TestParseScenario_Errors(t *testing.T) {
+	cases := map[string]string{
+		"scenario has no endpoints":   `endpoints: []`,
+		"url not set":                 "endpoints:\n  - name: a\n",
+		"unsupported method":          "endpoints:\n  - url: http://x\n    methods: {FETCH: 1}\n",
+		"all method weights are zero": "endpoints:\n  - url: http://x\n    methods: {GET: 0}\n",
+		"static payload needs a body": "endpoints:\n  - url: http://x\n    payload: static\n",
+		"unknown payload generator":   "endpoints:\n  - url: http://x\n    payload: xml\n",
+		"COLLECTOR_URL not set":       "endpoints:\n  - url: \"{base}/collect\"\n",
+	}
+
+	for expected, data := range cases {
+		s, err := ParseScenario([]byte(data), "")
+		assert.Nil(t, s, expected)
+		if assert.Error(t, err, expected) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
+
+func TestScenario_WeightedPicks(t *testing.T) {
+	s, err := ParseScenario([]byte(`
+endpoints:
+  - name: heavy
+    url: http://a
+    weight: 9
+    methods: {POST: 1}
+  - name: light
+    url: http://b
+    weight: 1
+    methods: {GET: 1, DELETE: 0}
+`), "")
+	assert.NoError(t, err)
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		req := s.NextRequest()
+		counts[req.Endpoint+" "+req.Method]++
+	}
+
+	assert.Zero(t, counts["light DELETE"])
+	assert.InDelta(t, 9000, counts["heavy POST"], 400)
+	assert.InDelta(t, 1000, counts["light GET"], 400)
+}
+
+func TestEndpoint_BuildRequestPayloads(t *testing.T) {
+	s := DefaultScenario("http://localhost:8080/collect")
+	ep := s.Endpoints[0]
+
+	assert.NotEmpty(t, ep.BuildRequest("POST").Body)
+	assert.Nil(t, ep.BuildRequest("GET").Body)
+
+	ep.Payload = "static"
+	ep.Body = `{"a":1}`
+	assert.Equal(t, []byte(`{"a":1}`), ep.BuildRequest("GET").Body)
+}
+
+func TestParseScenario_PayloadSize(t *testing.T) {
+	s, err := ParseScenario([]byte(`
+size: 1KB
+endpoints:
+  - name: big
+    url: http://x
+    methods: {POST: 1}
+    size: uniform 8KB 8KB
+  - name: default
+    url: http://x
+    methods: {POST: 1}
+  - name: bare
+    url: http://x
+    methods: {GET: 1}
+`), "")
+	assert.NoError(t, err)
+
+	assert.Len(t, s.Endpoints[0].BuildRequest("POST").Body, 8192)
+	assert.Len(t, s.Endpoints[1].BuildRequest("POST").Body, 1024)
+	assert.Nil(t, s.Endpoints[2].BuildRequest("GET").Body)
+
+	_, err = ParseScenario([]byte("endpoints:\n  - url: http://x\n    size: huge\n"), "")
+	assert.ErrorContains(t, err, "size")
+}
+
+func TestScenario_SetPayloadSize(t *testing.T) {
+	s := DefaultScenario("http://x")
+	s.SetPayloadSize(spec.FixedSize(2048))
+
+	body := s.Endpoints[0].BuildRequest("PUT").Body
+	assert.Len(t, body, 2048)
+	assert.True(t, json.Valid(body))
+}
@@ -0,0 +1,415 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// StatKey groups results by endpoint and method
+type StatKey struct {
+	Endpoint string
+	Method   string
+}
+
+// Stats collects per-request results of a run; it is safe for concurrent use
+type Stats struct {
+	mu        sync.Mutex
+	Start     time.Time
+	End       time.Time
+	Overall   *Histogram
+	Latencies map[StatKey]*Histogram
+	Errors    int64            // requests that got no response
+	ErrorKind map[string]int64 // Errors by class, see classifyError
+	Methods   map[string]int64
+	Statuses  map[int]int64
+	Completed []int64 // attempts finished in each second of the run
+
+	// Retried attempts are kept apart from first attempts
+	Retries       int64
+	RetryStatuses map[int]int64
+	RetryErrors   map[string]int64
+	RetryLatency  *Histogram
+	Recovered     int64 // requests that failed at first and succeeded on a retry
+	Exhausted     int64 // requests still failing after their last retry
+
+	// How an open mode run kept to its schedule
+	Due      int64      // requests the schedule called for
+	Delayed  int64      // started late because the in-flight cap was reached
+	Dropped  int64      // skipped because the in-flight cap was reached
+	StartLag *Histogram // time from each request falling due to it being sent
+
+	// Virtual user flows by name; flows cut short by the end of the run are in neither
+	FlowsCompleted map[string]int64
+	FlowsAborted   map[string]int64 // stopped at a failing step
+}
+
+// NewStats returns an empty collector starting now
+func NewStats() *Stats {
+	return &Stats{
+		Start:     time.Now(),
+		Overall:   NewHistogram(),
+		Latencies: make(map[StatKey]*Histogram),
+		ErrorKind: make(map[string]int64),
+		Methods:   make(map[string]int64),
+		Statuses:  make(map[int]int64),
+
+		RetryStatuses: make(map[int]int64),
+		RetryErrors:   make(map[string]int64),
+		RetryLatency:  NewHistogram(),
+		StartLag:      NewHistogram(),
+
+		FlowsCompleted: make(map[string]int64),
+		FlowsAborted:   make(map[string]int64),
+	}
+}
+
+// Record adds the outcome of one request. result carries the endpoint and
+// method even when err is set.
+func (s *Stats) Record(result *Result, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	second := int(time.Since(s.Start) / time.Second)
+	for len(s.Completed) <= second {
+		s.Completed = append(s.Completed, 0)
+	}
+	s.Completed[second]++
+
+	if result != nil && result.Attempt > 1 {
+		s.Retries++
+		if err != nil {
+			s.RetryErrors[classifyError(err)]++
+			return
+		}
+		s.RetryStatuses[result.StatusCode]++
+		s.RetryLatency.Record(result.Latency)
+		return
+	}
+
+	if result != nil {
+		s.Methods[result.Method]++
+	}
+
+	if err != nil {
+		s.Errors++
+		s.ErrorKind[classifyError(err)]++
+		return
+	}
+	s.Statuses[result.StatusCode]++
+
+	key := StatKey{Endpoint: result.Endpoint, Method: result.Method}
+	h, ok := s.Latencies[key]
+	if !ok {
+		h = NewHistogram()
+		s.Latencies[key] = h
+	}
+	h.Record(result.Latency)
+	s.Overall.Record(result.Latency)
+}
+
+// RecordOutcome adds the final attempt of a request to the retry outcome counters
+func (s *Stats) RecordOutcome(result *Result, err error) {
+	if result.Attempt <= 1 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil && result.StatusCode < 400 {
+		s.Recovered++
+	} else {
+		s.Exhausted++
+	}
+}
+
+// RecordStartLag adds how late a request was sent after it fell due
+func (s *Stats) RecordStartLag(lag time.Duration) {
+	s.mu.Lock()
+	s.StartLag.Record(lag)
+	s.mu.Unlock()
+}
+
+// RecordSchedule adds the schedule figures of an open mode run
+func (s *Stats) RecordSchedule(due, delayed, dropped int64) {
+	s.mu.Lock()
+	s.Due += due
+	s.Delayed += delayed
+	s.Dropped += dropped
+	s.mu.Unlock()
+}
+
+// RecordFlow adds one run of a virtual user flow, completed or aborted at a failing step
+func (s *Stats) RecordFlow(name string, completed bool) {
+	s.mu.Lock()
+	if completed {
+		s.FlowsCompleted[name]++
+	} else {
+		s.FlowsAborted[name]++
+	}
+	s.mu.Unlock()
+}
+
+// Finish marks the end of the run
+func (s *Stats) Finish() {
+	s.mu.Lock()
+	s.End = time.Now()
+	s.mu.Unlock()
+}
+
+// Elapsed returns the run time so far, or the full run time once finished
+func (s *Stats) Elapsed() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.End.IsZero() {
+		return time.Since(s.Start)
+	}
+	return s.End.Sub(s.Start)
+}
+
+// Merge adds the results of other, a finished run, to s. Per-second counts are
+// aligned on the run start times and the end moves to the later of the two.
+func (s *Stats) Merge(other *Stats) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if other.End.After(s.End) {
+		s.End = other.End
+	}
+
+	shift := int(other.Start.Sub(s.Start).Round(time.Second) / time.Second)
+	if shift < 0 {
+		shift = 0
+	}
+	for i, n := range other.Completed {
+		for len(s.Completed) <= i+shift {
+			s.Completed = append(s.Completed, 0)
+		}
+		s.Completed[i+shift] += n
+	}
+
+	s.Overall.Merge(other.Overall)
+	for key, h := range other.Latencies {
+		if _, ok := s.Latencies[key]; !ok {
+			s.Latencies[key] = NewHistogram()
+		}
+		s.Latencies[key].Merge(h)
+	}
+	s.Errors += other.Errors
+	mergeCounts(s.ErrorKind, other.ErrorKind)
+	mergeCounts(s.Methods, other.Methods)
+	mergeCounts(s.Statuses, other.Statuses)
+
+	s.Retries += other.Retries
+	mergeCounts(s.RetryStatuses, other.RetryStatuses)
+	mergeCounts(s.RetryErrors, other.RetryErrors)
+	s.RetryLatency.Merge(other.RetryLatency)
+	s.Recovered += other.Recovered
+	s.Exhausted += other.Exhausted
+
+	s.Due += other.Due
+	s.Delayed += other.Delayed
+	s.Dropped += other.Dropped
+	s.StartLag.Merge(other.StartLag)
+
+	mergeCounts(s.FlowsCompleted, other.FlowsCompleted)
+	mergeCounts(s.FlowsAborted, other.FlowsAborted)
+}
+
+func mergeCounts[K comparable](into, from map[K]int64) {
+	for k, n := range from {
+		into[k] += n
+	}
+}
+
+// statsJSON is the wire form of Stats; JSON objects can't have StatKey keys
+type statsJSON struct {
+	Start     time.Time        `json:"start"`
+	End       time.Time        `json:"end"`
+	Overall   *Histogram       `json:"overall"`
+	Latencies []keyedHistogram `json:"latencies"`
+	Errors    int64            `json:"errors"`
+	ErrorKind map[string]int64 `json:"error_kinds"`
+	Methods   map[string]int64 `json:"methods"`
+	Statuses  map[int]int64    `json:"statuses"`
+	Completed []int64          `json:"completed"`
+
+	Retries       int64            `json:"retries"`
+	RetryStatuses map[int]int64    `json:"retry_statuses"`
+	RetryErrors   map[string]int64 `json:"retry_errors"`
+	RetryLatency  *Histogram       `json:"retry_latency"`
+	Recovered     int64            `json:"recovered"`
+	Exhausted     int64            `json:"exhausted"`
+
+	Due      int64      `json:"due"`
+	Delayed  int64      `json:"delayed"`
+	Dropped  int64      `json:"dropped"`
+	StartLag *Histogram `json:"start_lag"`
+
+	FlowsCompleted map[string]int64 `json:"flows_completed"`
+	FlowsAborted   map[string]int64 `json:"flows_aborted"`
+}
+
+type keyedHistogram struct {
+	Endpoint  string     `json:"endpoint"`
+	Method    string     `json:"method"`
+	Histogram *Histogram `json:"histogram"`
+}
+
+// MarshalJSON encodes the collected results so workers can send them to a coordinator
+func (s *Stats) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j := statsJSON{
+		Start: s.Start, End: s.End, Overall: s.Overall,
+		Errors: s.Errors, ErrorKind: s.ErrorKind, Methods: s.Methods, Statuses: s.Statuses, Completed: s.Completed,
+		Retries: s.Retries, RetryStatuses: s.RetryStatuses, RetryErrors: s.RetryErrors, RetryLatency: s.RetryLatency,
+		Recovered: s.Recovered, Exhausted: s.Exhausted,
+		Due: s.Due, Delayed: s.Delayed, Dropped: s.Dropped, StartLag: s.StartLag,
+		FlowsCompleted: s.FlowsCompleted, FlowsAborted: s.FlowsAborted,
+	}
+	for _, k := range s.sortedKeys() {
+		j.Latencies = append(j.Latencies, keyedHistogram{Endpoint: k.Endpoint, Method: k.Method, Histogram: s.Latencies[k]})
+	}
+	return json.Marshal(j)
+}
+
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	var j statsJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	empty := NewStats()
+	s.Start, s.End, s.Completed = j.Start, j.End, j.Completed
+	s.Overall, s.RetryLatency, s.StartLag = empty.Overall, empty.RetryLatency, empty.StartLag
+	if j.Overall != nil {
+		s.Overall = j.Overall
+	}
+	if j.RetryLatency != nil {
+		s.RetryLatency = j.RetryLatency
+	}
+	if j.StartLag != nil {
+		s.StartLag = j.StartLag
+	}
+	s.Latencies = empty.Latencies
+	for _, kh := range j.Latencies {
+		s.Latencies[StatKey{Endpoint: kh.Endpoint, Method: kh.Method}] = kh.Histogram
+	}
+
+	s.Errors, s.Retries, s.Recovered, s.Exhausted = j.Errors, j.Retries, j.Recovered, j.Exhausted
+	s.Due, s.Delayed, s.Dropped = j.Due, j.Delayed, j.Dropped
+	s.ErrorKind, s.Methods, s.Statuses = empty.ErrorKind, empty.Methods, empty.Statuses
+	s.RetryStatuses, s.RetryErrors = empty.RetryStatuses, empty.RetryErrors
+	s.FlowsCompleted, s.FlowsAborted = empty.FlowsCompleted, empty.FlowsAborted
+	mergeCounts(s.ErrorKind, j.ErrorKind)
+	mergeCounts(s.Methods, j.Methods)
+	mergeCounts(s.Statuses, j.Statuses)
+	mergeCounts(s.RetryStatuses, j.RetryStatuses)
+	mergeCounts(s.RetryErrors, j.RetryErrors)
+	mergeCounts(s.FlowsCompleted, j.FlowsCompleted)
+	mergeCounts(s.FlowsAborted, j.FlowsAborted)
+	return nil
+}
+
+// classifyError maps a transport error to a short class for error breakdowns
+func classifyError(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection_reset"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	default:
+		return "other"
+	}
+}
+
+// sortedKeys returns the latency keys in a stable order
+func (s *Stats) sortedKeys() []StatKey {
+	keys := make([]StatKey, 0, len(s.Latencies))
+	for k := range s.Latencies {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Endpoint != keys[j].Endpoint {
+			return keys[i].Endpoint < keys[j].Endpoint
+		}
+		return keys[i].Method < keys[j].Method
+	})
+	return keys
+}
+
+// reportPercentiles are the percentiles shown in summaries
+var reportPercentiles = []float64{50, 90, 95, 99, 99.9}
+
+// PrintLatencyReport writes a latency table per endpoint and method, plus a total row
+func (s *Stats) PrintLatencyReport(out io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "endpoint\tmethod\tcount\tmin\tmean\tp50\tp90\tp95\tp99\tp99.9\tmax\t")
+
+	row := func(endpoint, method string, h *Histogram) {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%v\t%v\t", endpoint, method, h.Total, h.MinLatency(), h.Mean())
+		for _, p := range reportPercentiles {
+			fmt.Fprintf(w, "%v\t", h.Percentile(p))
+		}
+		fmt.Fprintf(w, "%v\t\n", h.MaxLatency())
+	}
+
+	for _, k := range s.sortedKeys() {
+		row(k.Endpoint, k.Method, s.Latencies[k])
+	}
+	row("all", "", s.Overall)
+	w.Flush()
+
+	if s.Errors > 0 {
+		fmt.Fprintf(out, "Failed requests: %d\n", s.Errors)
+	}
+	if s.Retries > 0 {
+		fmt.Fprintf(out, "Retries: %d (recovered %d, exhausted %d), retry p50 %v, p99 %v\n",
+			s.Retries, s.Recovered, s.Exhausted, s.RetryLatency.Percentile(50), s.RetryLatency.Percentile(99))
+	}
+	if s.Due > 0 {
+		fmt.Fprintf(out, "Schedule: %d due, %d delayed and %d dropped by the in-flight cap, start lag p50 %v, p99 %v, max %v\n",
+			s.Due, s.Delayed, s.Dropped, s.StartLag.Percentile(50), s.StartLag.Percentile(99), s.StartLag.MaxLatency())
+	}
+	for _, name := range s.flowNames() {
+		fmt.Fprintf(out, "Flow %s: %d completed, %d aborted\n", name, s.FlowsCompleted[name], s.FlowsAborted[name])
+	}
+}
+
+// flowNames returns the names of the flows that ran, sorted
+func (s *Stats) flowNames() []string {
+	names := make([]string, 0, len(s.FlowsCompleted)+len(s.FlowsAborted))
+	for name := range s.FlowsCompleted {
+		names = append(names, name)
+	}
+	for name := range s.FlowsAborted {
+		if _, ok := s.FlowsCompleted[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
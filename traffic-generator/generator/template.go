@@ -0,0 +1,338 @@
+package generator
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PayloadTemplate builds JSON bodies from a template tree. Leaves that are maps
+// with a "type" key are generators; other maps become objects, lists become
+// arrays and scalars are copied as they are. Generator types:
+//
+//	int        min, max
+//	float      min, max, decimals (default 2)
+//	bool
+//	string     min, max (length, default 8..16)
+//	uuid
+//	name       a random "First Last"
+//	email
+//	timestamp  format: rfc3339 (default) or unix; offset: duration spread back from now
+//	enum       values: [...], optional weights: [...]
+//	array      items: <template>, min, max (length, default 1..3)
+//	object     fields: {name: <template>}
+type PayloadTemplate struct {
+	root valueGen
+}
+
+// valueGen produces one value of a template
+type valueGen func(r *rand.Rand) interface{}
+
+// ParsePayloadTemplate compiles a template tree decoded from YAML
+func ParsePayloadTemplate(tree interface{}) (*PayloadTemplate, error) {
+	gen, err := compileTemplate(tree, "template")
+	if err != nil {
+		return nil, err
+	}
+	return &PayloadTemplate{root: gen}, nil
+}
+
+// Render generates one JSON body
+func (t *PayloadTemplate) Render(r *rand.Rand) []byte {
+	data, err := json.Marshal(t.root(r))
+	if err != nil {
+		// compileTemplate only produces JSON-safe values
+		panic(fmt.Sprintf("payload template produced invalid JSON: %v", err))
+	}
+	return data
+}
+
+// toStringMap converts the map types YAML decoders produce
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			out[fmt.Sprint(k)] = val
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// numberField reads an optional numeric option, falling back to def
+func numberField(spec map[string]interface{}, key, path string, def float64) (float64, error) {
+	v, ok := spec[key]
+	if !ok {
+		return def, nil
+	}
+	n, ok := toFloat(v)
+	if !ok {
+		return 0, fmt.Errorf("%s: %s must be a number", path, key)
+	}
+	return n, nil
+}
+
+// rangeFields reads min and max, checking min <= max
+func rangeFields(spec map[string]interface{}, path string, defMin, defMax float64) (float64, float64, error) {
+	min, err := numberField(spec, "min", path, defMin)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := numberField(spec, "max", path, defMax)
+	if err != nil {
+		return 0, 0, err
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("%s: min must not be greater than max", path)
+	}
+	return min, max, nil
+}
+
+func compileTemplate(tree interface{}, path string) (valueGen, error) {
+	if list, ok := tree.([]interface{}); ok {
+		items := make([]valueGen, len(list))
+		for i, item := range list {
+			gen, err := compileTemplate(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = gen
+		}
+		return func(r *rand.Rand) interface{} {
+			out := make([]interface{}, len(items))
+			for i, gen := range items {
+				out[i] = gen(r)
+			}
+			return out
+		}, nil
+	}
+
+	spec, ok := toStringMap(tree)
+	if !ok {
+		// scalars are constants
+		return func(*rand.Rand) interface{} { return tree }, nil
+	}
+
+	kind, isGenerator := spec["type"].(string)
+	if !isGenerator {
+		return compileObject(spec, path)
+	}
+
+	switch kind {
+	case "int":
+		min, max, err := rangeFields(spec, path, 0, 1000)
+		if err != nil {
+			return nil, err
+		}
+		// The span of values has to fit an int64 for Int63n
+		if min < math.MinInt64 || max >= math.MaxInt64 || max-min >= math.MaxInt64 {
+			return nil, fmt.Errorf("%s: min and max are too far apart for an int", path)
+		}
+		lo, span := int64(min), int64(max)-int64(min)+1
+		return func(r *rand.Rand) interface{} { return lo + r.Int63n(span) }, nil
+
+	case "float":
+		min, max, err := rangeFields(spec, path, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		decimals, err := numberField(spec, "decimals", path, 2)
+		if err != nil {
+			return nil, err
+		}
+		scale := math.Pow(10, decimals)
+		return func(r *rand.Rand) interface{} {
+			return math.Round((min+r.Float64()*(max-min))*scale) / scale
+		}, nil
+
+	case "bool":
+		return func(r *rand.Rand) interface{} { return r.Intn(2) == 1 }, nil
+
+	case "string":
+		min, max, err := rangeFields(spec, path, 8, 16)
+		if err != nil {
+			return nil, err
+		}
+		if min < 0 {
+			return nil, fmt.Errorf("%s: min must not be negative", path)
+		}
+		return func(r *rand.Rand) interface{} {
+			return randomString(r, int(min)+r.Intn(int(max-min)+1))
+		}, nil
+
+	case "uuid":
+		return func(r *rand.Rand) interface{} { return randomUUID(r) }, nil
+
+	case "name":
+		return func(r *rand.Rand) interface{} {
+			return firstNames[r.Intn(len(firstNames))] + " " + lastNames[r.Intn(len(lastNames))]
+		}, nil
+
+	case "email":
+		return func(r *rand.Rand) interface{} {
+			return fmt.Sprintf("%s.%s%d@example.com",
+				strings.ToLower(firstNames[r.Intn(len(firstNames))]),
+				strings.ToLower(lastNames[r.Intn(len(lastNames))]),
+				r.Intn(1000))
+		}, nil
+
+	case "timestamp":
+		format, _ := spec["format"].(string)
+		if format != "" && format != "rfc3339" && format != "unix" {
+			return nil, fmt.Errorf("%s: timestamp format must be rfc3339 or unix", path)
+		}
+		var offset time.Duration
+		if v, ok := spec["offset"]; ok {
+			var err error
+			offset, err = time.ParseDuration(fmt.Sprint(v))
+			if err != nil || offset < 0 {
+				return nil, fmt.Errorf("%s: invalid timestamp offset %v", path, v)
+			}
+		}
+		return func(r *rand.Rand) interface{} {
+			ts := time.Now()
+			if offset > 0 {
+				ts = ts.Add(-time.Duration(r.Int63n(int64(offset))))
+			}
+			if format == "unix" {
+				return ts.Unix()
+			}
+			return ts.UTC().Format(time.RFC3339)
+		}, nil
+
+	case "enum":
+		values, ok := spec["values"].([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("%s: enum needs a non-empty values list", path)
+		}
+		weights := make([]int, len(values))
+		total := 0
+		if raw, ok := spec["weights"].([]interface{}); ok {
+			if len(raw) != len(values) {
+				return nil, fmt.Errorf("%s: enum needs one weight per value", path)
+			}
+			for i, w := range raw {
+				n, ok := toFloat(w)
+				if !ok || n < 0 {
+					return nil, fmt.Errorf("%s: enum weights must be non-negative numbers", path)
+				}
+				weights[i] = int(n)
+				total += int(n)
+			}
+		} else {
+			for i := range weights {
+				weights[i] = 1
+			}
+			total = len(values)
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("%s: enum weights are all zero", path)
+		}
+		return func(r *rand.Rand) interface{} { return values[pickWeightedWith(r, weights, total)] }, nil
+
+	case "array":
+		items, ok := spec["items"]
+		if !ok {
+			return nil, fmt.Errorf("%s: array needs items", path)
+		}
+		item, err := compileTemplate(items, path+".items")
+		if err != nil {
+			return nil, err
+		}
+		min, max, err := rangeFields(spec, path, 1, 3)
+		if err != nil {
+			return nil, err
+		}
+		if min < 0 {
+			return nil, fmt.Errorf("%s: array length must not be negative", path)
+		}
+		return func(r *rand.Rand) interface{} {
+			out := make([]interface{}, int(min)+r.Intn(int(max-min)+1))
+			for i := range out {
+				out[i] = item(r)
+			}
+			return out
+		}, nil
+
+	case "object":
+		fields, ok := toStringMap(spec["fields"])
+		if !ok {
+			return nil, fmt.Errorf("%s: object needs fields", path)
+		}
+		return compileObject(fields, path)
+
+	default:
+		return nil, fmt.Errorf("%s: unknown generator type %q", path, kind)
+	}
+}
+
+func compileObject(fields map[string]interface{}, path string) (valueGen, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gens := make([]valueGen, len(names))
+	for i, name := range names {
+		gen, err := compileTemplate(fields[name], path+"."+name)
+		if err != nil {
+			return nil, err
+		}
+		gens[i] = gen
+	}
+
+	// fields are generated in name order so a seeded run is reproducible
+	return func(r *rand.Rand) interface{} {
+		out := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			out[name] = gens[i](r)
+		}
+		return out
+	}, nil
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomString(r *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[r.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// randomUUID returns a version 4 UUID drawn from r
+func randomUUID(r *rand.Rand) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:8], r.Uint64())
+	binary.BigEndian.PutUint64(b[8:16], r.Uint64())
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+var firstNames = []string{"Ada", "Alan", "Grace", "Linus", "Margaret", "Dennis", "Barbara", "Ken", "Frances", "John", "Radia", "Guido"}
+var lastNames = []string{"Lovelace", "Turing", "Hopper", "Torvalds", "Hamilton", "Ritchie", "Liskov", "Thompson", "Allen", "Backus", "Perlman", "Rossum"}
@@ -0,0 +1,117 @@
+package generator
+
+import (
+	"encoding/json"
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func parseTemplate(t *testing.T, src string) *PayloadTemplate {
+	t.Helper()
+	var tree interface{}
+	if err := yaml.Unmarshal([]byte(src), &tree); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := ParsePayloadTemplate(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestPayloadTemplate_Types(t *testing.T) {
+	tmpl := parseTemplate(t, `
+id: {type: uuid}
+count: {type: int, min: 5, max: 7}
+price: {type: float, min: 1, max: 2, decimals: 1}
+active: {type: bool}
+code: {type: string, min: 4, max: 4}
+name: {type: name}
+email: {type: email}
+at: {type: timestamp, format: unix, offset: 1h}
+status: {type: enum, values: [a, b], weights: [1, 0]}
+tags: {type: array, min: 2, max: 2, items: {type: enum, values: [x]}}
+meta: {type: object, fields: {version: 1}}
+fixed: [1, two]
+source: test
+`)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		var body map[string]interface{}
+		assert.NoError(t, json.Unmarshal(tmpl.Render(r), &body))
+
+		assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), body["id"])
+		assert.GreaterOrEqual(t, body["count"], 5.0)
+		assert.LessOrEqual(t, body["count"], 7.0)
+		assert.GreaterOrEqual(t, body["price"], 1.0)
+		assert.LessOrEqual(t, body["price"], 2.0)
+		assert.IsType(t, true, body["active"])
+		assert.Len(t, body["code"], 4)
+		assert.Contains(t, body["email"], "@example.com")
+		assert.Equal(t, "a", body["status"])
+		assert.Equal(t, []interface{}{"x", "x"}, body["tags"])
+		assert.Equal(t, map[string]interface{}{"version": 1.0}, body["meta"])
+		assert.Equal(t, []interface{}{1.0, "two"}, body["fixed"])
+		assert.Equal(t, "test", body["source"])
+	}
+}
+
+func TestPayloadTemplate_SeededRenderIsReproducible(t *testing.T) {
+	tmpl := parseTemplate(t, `
+id: {type: uuid}
+user: {name: {type: name}, age: {type: int, min: 18, max: 90}}
+items: {type: array, max: 5, items: {type: string}}
+`)
+
+	a := tmpl.Render(rand.New(rand.NewSource(42)))
+	b := tmpl.Render(rand.New(rand.NewSource(42)))
+
+	assert.Equal(t, a, b)
+}
+
+func TestPayloadTemplate_Errors(t *testing.T) {
+	cases := map[string]string{
+		`unknown generator type "color"`:        `c: {type: color}`,
+		"template.num: min must not be greater": `num: {type: int, min: 5, max: 1}`,
+		"max must be a number":                  `s: {type: string, max: long}`,
+		"template.s: min must not be negative":  `s: {type: string, min: -3, max: 4}`,
+		"too far apart for an int":              `num: {type: int, min: -9223372036854775808, max: 9223372036854775807}`,
+		"enum needs a non-empty values list":    `e: {type: enum}`,
+		"enum needs one weight per value":       `e: {type: enum, values: [a, b], weights: [1]}`,
+		"enum weights are all zero":             `e: {type: enum, values: [a], weights: [0]}`,
+		"array needs items":                     `a: {type: array}`,
+		"template.a.items.x: unknown":           `a: {type: array, items: {x: {type: nope}}}`,
+		"object needs fields":                   `o: {type: object}`,
+		"timestamp format must be":              `t: {type: timestamp, format: iso}`,
+	}
+
+	for want, src := range cases {
+		var tree interface{}
+		assert.NoError(t, yaml.Unmarshal([]byte(src), &tree))
+		_, err := ParsePayloadTemplate(tree)
+		if assert.Error(t, err, src) {
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+}
+
+func TestParseScenario_TemplatePayload(t *testing.T) {
+	s, err := ParseScenario([]byte(`
+endpoints:
+  - url: http://x
+    methods: {POST: 1}
+    payload: template
+    template:
+      id: {type: int, min: 1, max: 1}
+`), "")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id": 1}`, string(s.NextRequest().Body))
+
+	_, err = ParseScenario([]byte("endpoints:\n  - url: http://x\n    payload: template\n"), "")
+	assert.ErrorContains(t, err, "template payload needs a template")
+}
@@ -0,0 +1,118 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"traffic-generator/spec"
+)
+
+// ThresholdResult is a threshold checked against a run
+type ThresholdResult struct {
+	spec.Threshold
+	Actual float64 `json:"actual"`
+	Passed bool    `json:"passed"`
+}
+
+// EvaluateThresholds checks each threshold against the results so far and
+// reports whether all of them passed
+func EvaluateThresholds(thresholds []spec.Threshold, stats *Stats) ([]ThresholdResult, bool) {
+	sum := stats.Summary()
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	passed := true
+	results := make([]ThresholdResult, len(thresholds))
+	for i, t := range thresholds {
+		var actual float64
+		switch {
+		case t.Metric == "mean":
+			actual = millis(stats.Overall.Mean())
+		case t.Metric == "min":
+			actual = millis(stats.Overall.MinLatency())
+		case t.Metric == "max":
+			actual = millis(stats.Overall.MaxLatency())
+		case strings.HasPrefix(t.Metric, "p"):
+			p, _ := strconv.ParseFloat(t.Metric[1:], 64)
+			actual = millis(stats.Overall.Percentile(p))
+		case t.Metric == "error_rate":
+			actual = errorRate(sum)
+		case t.Metric == "rps":
+			actual = sum.RequestsPerSecond
+		case t.Metric == "requests":
+			actual = float64(sum.TotalRequests)
+		}
+
+		results[i] = ThresholdResult{Threshold: t, Actual: actual, Passed: compare(actual, t.Op, t.Value)}
+		passed = passed && results[i].Passed
+	}
+	return results, passed
+}
+
+// errorRate is the share of requests whose final attempt failed: a request
+// that failed at first and succeeded on a retry counts as a success
+func errorRate(sum *Summary) float64 {
+	if sum.TotalRequests == 0 {
+		return 0
+	}
+	failed := sum.Failed
+	if sum.Retries != nil {
+		failed -= sum.Retries.Recovered
+	}
+	return float64(failed) / float64(sum.TotalRequests)
+}
+
+func compare(actual float64, op string, value float64) bool {
+	switch op {
+	case "<":
+		return actual < value
+	case "<=":
+		return actual <= value
+	case ">":
+		return actual > value
+	case ">=":
+		return actual >= value
+	}
+	return false
+}
+
+// FormatActual shows the measured value in the metric's unit
+func (r ThresholdResult) FormatActual() string {
+	switch {
+	case r.Latency():
+		return fmt.Sprintf("%.2fms", r.Actual)
+	case r.Metric == "error_rate":
+		return fmt.Sprintf("%.2f%%", r.Actual*100)
+	case r.Metric == "rps":
+		return fmt.Sprintf("%.2f/s", r.Actual)
+	default:
+		return fmt.Sprintf("%.0f", r.Actual)
+	}
+}
+
+// checkAbort returns the first failing abortable threshold of a, if the run may be judged yet
+func checkAbort(a *spec.ThresholdAbort, stats *Stats) (ThresholdResult, bool) {
+	minSamples := a.MinSamples
+	if minSamples <= 0 {
+		minSamples = 100
+	}
+	sum := stats.Summary()
+	if sum.DurationSeconds < a.Grace.Seconds() || sum.TotalRequests < minSamples {
+		return ThresholdResult{}, false
+	}
+
+	var abortable []spec.Threshold
+	for _, t := range a.Thresholds {
+		if t.Abortable() {
+			abortable = append(abortable, t)
+		}
+	}
+	results, _ := EvaluateThresholds(abortable, stats)
+	for _, r := range results {
+		if !r.Passed {
+			return r, true
+		}
+	}
+	return ThresholdResult{}, false
+}
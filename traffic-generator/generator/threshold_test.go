@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
+)
+
+func TestEvaluateThresholds(t *testing.T) {
+	thresholds, err := spec.ParseThresholds("max < 10ms, p50 < 1ms, error_rate < 10%, requests >= 4")
+	assert.NoError(t, err)
+
+	results, passed := EvaluateThresholds(thresholds, sampleStats())
+
+	assert.False(t, passed)
+	assert.Equal(t, []bool{true, false, false, true}, []bool{results[0].Passed, results[1].Passed, results[2].Passed, results[3].Passed})
+	assert.Equal(t, 0.5, results[2].Actual)
+	assert.Equal(t, "50.00%", results[2].FormatActual())
+	assert.Equal(t, "4", results[3].FormatActual())
+}
+
+func TestEvaluateThresholds_ErrorRateUsesFinalOutcome(t *testing.T) {
+	thresholds, err := spec.ParseThresholds("error_rate < 30%")
+	assert.NoError(t, err)
+
+	// Four requests fail at first; a retry recovers three of them
+	stats := NewStats()
+	for i := 0; i < 4; i++ {
+		stats.Record(&Result{Endpoint: "e", Method: "GET", StatusCode: 503, Attempt: 1}, nil)
+		status := http.StatusOK
+		if i == 3 {
+			status = http.StatusServiceUnavailable
+		}
+		retry := &Result{Endpoint: "e", Method: "GET", StatusCode: status, Attempt: 2}
+		stats.Record(retry, nil)
+		stats.RecordOutcome(retry, nil)
+	}
+
+	results, passed := EvaluateThresholds(thresholds, stats)
+
+	assert.True(t, passed)
+	assert.Equal(t, 0.25, results[0].Actual)
+}
+
+func TestThresholdAbort_WaitsForGraceAndSamples(t *testing.T) {
+	thresholds, err := spec.ParseThresholds("error_rate < 1%, requests > 100")
+	assert.NoError(t, err)
+	stats := sampleStats()
+
+	_, breached := checkAbort(&spec.ThresholdAbort{Thresholds: thresholds, MinSamples: 10}, stats)
+	assert.False(t, breached, "too few samples")
+
+	_, breached = checkAbort(&spec.ThresholdAbort{Thresholds: thresholds, MinSamples: 1, Grace: time.Hour}, stats)
+	assert.False(t, breached, "within the grace period")
+
+	failed, breached := checkAbort(&spec.ThresholdAbort{Thresholds: thresholds, MinSamples: 1}, stats)
+	assert.True(t, breached)
+	assert.Equal(t, "error_rate", failed.Metric)
+
+	// request counts are still growing mid-run, so they never abort it
+	thresholds, _ = spec.ParseThresholds("requests > 100")
+	_, breached = checkAbort(&spec.ThresholdAbort{Thresholds: thresholds, MinSamples: 1}, stats)
+	assert.False(t, breached)
+}
+
+func TestRun_AbortsOnBreachedThreshold(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	thresholds, err := spec.ParseThresholds("error_rate < 1%")
+	assert.NoError(t, err)
+
+	for _, mode := range []string{"open", "closed"} {
+		start := time.Now()
+		stats := Run(Options{
+			Mode:         mode,
+			Duration:     10 * time.Second,
+			Interval:     5 * time.Millisecond,
+			VirtualUsers: 2,
+			ThinkTime:    5 * time.Millisecond,
+			Abort:        &spec.ThresholdAbort{Thresholds: thresholds, MinSamples: 20},
+		}, DefaultScenario(server.URL))
+
+		assert.Less(t, time.Since(start), 5*time.Second, mode)
+		_, passed := EvaluateThresholds(thresholds, stats)
+		assert.False(t, passed, mode)
+	}
+}
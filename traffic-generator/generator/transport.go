@@ -0,0 +1,200 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"traffic-generator/spec"
+)
+
+// Client sends requests over a shared, configured transport
+type Client struct {
+	opts   spec.TransportOptions
+	client *http.Client
+}
+
+// DefaultClient is used by Request.Send
+var DefaultClient = NewClient(spec.TransportOptions{})
+
+// NewClient builds a client from opts
+func NewClient(opts spec.TransportOptions) *Client {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 100
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 90 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.TLSHandshakeTimeout <= 0 {
+		opts.TLSHandshakeTimeout = 10 * time.Second
+	}
+
+	c := &Client{opts: opts}
+	c.client = &http.Client{Transport: c.newTransport(), Timeout: opts.Timeout}
+	return c
+}
+
+func (c *Client) newTransport() *http.Transport {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   c.opts.Timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          c.opts.PoolSize,
+		MaxIdleConnsPerHost:   c.opts.PoolSize,
+		IdleConnTimeout:       c.opts.IdleTimeout,
+		TLSHandshakeTimeout:   c.opts.TLSHandshakeTimeout,
+		DisableKeepAlives:     c.opts.DisableKeepAlives || c.opts.NewConnectionPerRequest,
+		ForceAttemptHTTP2:     !c.opts.DisableHTTP2,
+		ExpectContinueTimeout: time.Second,
+	}
+	if c.opts.DisableHTTP2 {
+		// a non-nil empty map switches off the transport's built-in HTTP/2
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// Do sends a materialized request and measures the response. The result is
+// returned even on error so failed attempts can be logged with their timing.
+func (c *Client) Do(r *Request) (*Result, error) {
+	result := &Result{
+		Endpoint:  r.Endpoint,
+		Method:    r.Method,
+		URL:       r.URL,
+		BytesSent: int64(len(r.Body)),
+	}
+
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+
+	// Connection timings separate connection setup from server time. Dials can
+	// finish on transport goroutines after Do returns, so the trace writes to
+	// locals under a lock and the result takes a snapshot when Do returns.
+	var mu sync.Mutex
+	var connectStart, tlsStart, sendStart time.Time
+	var connectTime, tlsTime, ttfb time.Duration
+	var reused bool
+	timed := func(f func()) {
+		mu.Lock()
+		f()
+		mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		ConnectStart:      func(string, string) { timed(func() { connectStart = time.Now() }) },
+		ConnectDone:       func(string, string, error) { timed(func() { connectTime = time.Since(connectStart) }) },
+		TLSHandshakeStart: func() { timed(func() { tlsStart = time.Now() }) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { timed(func() { tlsTime = time.Since(tlsStart) }) },
+		GotConn:           func(info httptrace.GotConnInfo) { timed(func() { reused = info.Reused }) },
+		GotFirstResponseByte: func() {
+			timed(func() { ttfb = time.Since(sendStart) })
+		},
+	}
+	snapshot := func() *Result {
+		timed(func() {
+			result.ConnectTime = connectTime
+			result.TLSTime = tlsTime
+			result.TimeToFirstByte = ttfb
+			result.ConnReused = reused
+		})
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), r.Method, r.URL, body)
+	if err != nil {
+		return result, fmt.Errorf("error creating request: %w", err)
+	}
+
+	if err := r.setHeaders(req); err != nil {
+		return result, err
+	}
+	result.sentHeader = req.Header
+
+	client := c.client
+	if c.opts.NewConnectionPerRequest {
+		transport := c.newTransport()
+		defer transport.CloseIdleConnections()
+		client = &http.Client{Transport: transport, Timeout: c.opts.Timeout}
+	}
+
+	// Send the request
+	start := time.Now()
+	timed(func() { sendStart = start })
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Latency = time.Since(start)
+		return snapshot(), fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Latency covers the whole response, so drain the body before stopping the clock
+	var kept *bytes.Buffer
+	sink := io.Discard
+	if r.KeepResponse {
+		kept = &bytes.Buffer{}
+		sink = &limitedWriter{w: kept, n: maxKeptBody}
+	}
+	result.BytesReceived, err = io.Copy(sink, resp.Body)
+	result.Latency = time.Since(start)
+	result.StatusCode = resp.StatusCode
+	if kept != nil {
+		result.Header, result.Body = resp.Header, kept.Bytes()
+	}
+	result.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if err != nil {
+		return snapshot(), fmt.Errorf("error reading response: %w", err)
+	}
+
+	return snapshot(), nil
+}
+
+// setHeaders sets the request's headers on req, including the credentials its auth adds
+func (r *Request) setHeaders(req *http.Request) error {
+	if r.Body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	if r.Auth != nil {
+		if err := r.Auth.Authenticate(req, r.Body); err != nil {
+			return fmt.Errorf("error authenticating request: %w", err)
+		}
+	}
+	return nil
+}
+
+// maxKeptBody caps the response body kept for flow extraction
+const maxKeptBody = 1 << 20
+
+// limitedWriter keeps the first n bytes written to it and discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if keep := min(len(p), l.n); keep > 0 {
+		l.w.Write(p[:keep])
+		l.n -= keep
+	}
+	return len(p), nil
+}
+
+// CloseIdleConnections releases pooled connections at the end of a run
+func (c *Client) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"traffic-generator/spec"
+)
+
+func TestClient_TimeoutStopsHungTarget(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClient(spec.TransportOptions{Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	result, err := client.Do(&Request{Method: "GET", URL: server.URL})
+
+	assert.Error(t, err)
+	assert.Equal(t, "timeout", classifyError(err))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.NotNil(t, result)
+}
+
+func TestClient_ReusesConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := NewClient(spec.TransportOptions{})
+	first, err := client.Do(&Request{Method: "GET", URL: server.URL})
+	assert.NoError(t, err)
+	second, err := client.Do(&Request{Method: "POST", URL: server.URL, Body: []byte(`{}`)})
+	assert.NoError(t, err)
+
+	assert.False(t, first.ConnReused)
+	assert.True(t, second.ConnReused)
+	assert.Equal(t, int64(2), second.BytesReceived)
+	assert.Equal(t, int64(2), second.BytesSent)
+	assert.Equal(t, http.StatusOK, second.StatusCode)
+}
+
+func TestClient_NewConnectionPerRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewClient(spec.TransportOptions{NewConnectionPerRequest: true})
+	for i := 0; i < 3; i++ {
+		result, err := client.Do(&Request{Method: "GET", URL: server.URL})
+		assert.NoError(t, err)
+		assert.False(t, result.ConnReused)
+		assert.Greater(t, result.ConnectTime, time.Duration(0))
+	}
+}
+
+func TestClient_PoolSizeDoesNotCapConcurrency(t *testing.T) {
+	var max int64
+	server := concurrencyServer(50*time.Millisecond, &max)
+	defer server.Close()
+
+	// Requests beyond the pool must not queue inside the transport, where the
+	// wait would be measured as server latency
+	client := NewClient(spec.TransportOptions{PoolSize: 2})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Do(&Request{Method: "GET", URL: server.URL})
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(6), atomic.LoadInt64(&max))
+}
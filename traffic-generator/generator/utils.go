@@ -4,13 +4,64 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"sync"
+	"time"
+
+	"traffic-generator/spec"
 )
 
+// lockedSource makes a rand.Source safe to share between goroutines
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+var (
+	rngSource      = newLockedSource(time.Now().UnixNano())
+	retryRngSource = newLockedSource(time.Now().UnixNano())
+)
+
+func newLockedSource(seed int64) *lockedSource {
+	return &lockedSource{src: rand.NewSource(seed).(rand.Source64)}
+}
+
+// rng drives scenario picks and payloads. Don't call rng.Read, it is not goroutine safe.
+var rng = rand.New(rngSource)
+
+// retryRng randomizes retry backoff. It is a separate stream so retries, whose
+// timing varies between runs, don't shift the requests drawn from rng.
+var retryRng = rand.New(retryRngSource)
+
+// SetSeed reseeds every random decision the engine makes from one run seed.
+// It seeds the sources, not the rand.Rand wrappers, which aren't safe to reseed concurrently.
+func SetSeed(seed int64) {
+	rngSource.Seed(seed)
+	retryRngSource.Seed(spec.DeriveSeed(seed, "retry"))
+}
+
 func RandomData() []byte {
 	data := map[string]interface{}{
-		"id":    rand.Intn(1000),
-		"value": rand.Float64() * 100,
-		"info":  fmt.Sprintf("RandomInfo%d", rand.Intn(100)),
+		"id":    rng.Intn(1000),
+		"value": rng.Float64() * 100,
+		"info":  fmt.Sprintf("RandomInfo%d", rng.Intn(100)),
 	}
 	jsonData, _ := json.Marshal(data)
 	return jsonData
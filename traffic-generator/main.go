@@ -1,29 +1,404 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"traffic-generator/config"
 	"traffic-generator/generator"
 )
 
+// settings collects repeated -set KEY=VALUE flags
+type settings map[string]string
+
+func (s settings) String() string { return fmt.Sprint(map[string]string(s)) }
+
+func (s settings) Set(pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("use KEY=VALUE")
+	}
+	s[strings.ToUpper(strings.TrimSpace(key))] = value
+	return nil
+}
+
 func main() {
-	// Clear log file on restart
-	err := os.WriteFile("log.txt", []byte{}, 0644)
+	overrides := settings{}
+	configFile := flag.String("config", "", "config file (default config.yaml, skipped when missing)")
+	seed := flag.Int64("seed", 0, "run seed, overrides SEED")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flag.Var(overrides, "set", "set a config key, e.g. -set API_RATE=50/s (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [validate] [flags]\n\nvalidate checks the configuration and exits without sending traffic.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	args := os.Args[1:]
+	validateOnly := len(args) > 0 && args[0] == "validate"
+	if validateOnly {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	if *seed != 0 {
+		overrides["SEED"] = strconv.FormatInt(*seed, 10)
+	}
+
+	// Load Configuration: defaults, then the config file, environment variables and flags
+	cfg, effective, err := config.Load(config.LoadOptions{
+		File:         *configFile,
+		FileRequired: *configFile != "",
+		Env:          os.Environ(),
+		Flags:        overrides,
+	})
+	if *printConfig && effective != nil {
+		effective.Print(os.Stdout)
+	}
+	if validateOnly {
+		os.Exit(validate(cfg, err))
+	}
 	if err != nil {
-		fmt.Println("Error clearing log file:", err)
+		log.Fatalf("Error reading config: %v", err)
+	}
+	if *printConfig {
+		return
+	}
+
+	// Every random decision of the run flows from this seed; rerun with -seed to reproduce it
+	generator.SetSeed(cfg.Seed)
+	fmt.Printf("Seed: %d\n", cfg.Seed)
+
+	// Live counters for dashboards; a coordinator sends nothing itself, so only senders serve them
+	var metrics *generator.Metrics
+	if cfg.MetricsListen != "" && cfg.Role != "coordinator" {
+		metrics = generator.NewMetrics()
+		generator.ServeMetrics(cfg.MetricsListen, metrics)
+		fmt.Println("Serving metrics on", cfg.MetricsListen+"/metrics")
+	}
+
+	var stats *generator.Stats
+	var runErr error
+	switch cfg.Role {
+	case "worker":
+		serveWorker(cfg, metrics)
 		return
+	case "coordinator":
+		stats, runErr = coordinate(cfg)
+	default:
+		stats = runStandalone(cfg, metrics)
 	}
 
-	// Load Configuration
-	cfg, err := config.ReadConfig() // ✅ Rename local variable to `cfg`
+	fmt.Println("Latency:")
+	stats.PrintLatencyReport(os.Stdout)
+
+	summary := stats.Summary()
+	passed := true
+	if cfg.Thresholds != nil {
+		summary.Thresholds, passed = generator.EvaluateThresholds(cfg.Thresholds, stats)
+		printThresholds(summary.Thresholds)
+	}
+
+	if cfg.ReportFile != "" {
+		if err := generator.WriteReport(cfg.ReportFile, cfg.ReportFormat, summary); err != nil {
+			log.Fatalf("Error writing report: %v", err)
+		}
+		fmt.Println("Report written to", cfg.ReportFile)
+	}
+	fmt.Println("Traffic Generator finished.")
+
+	if runErr != nil {
+		log.Fatalf("Error running workers: %v", runErr)
+	}
+	if !passed {
+		os.Exit(thresholdsFailedExitCode)
+	}
+}
+
+// thresholdsFailedExitCode tells a CI job the run completed but missed its
+// thresholds, apart from the exit code 1 of configuration and runtime errors
+const thresholdsFailedExitCode = 99
+
+// printThresholds lists each threshold with its measured value and whether it passed
+func printThresholds(results []generator.ThresholdResult) {
+	fmt.Println("Thresholds:")
+	failed := 0
+	for _, r := range results {
+		status := "PASS"
+		if !r.Passed {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("  %s  %-24s actual %s\n", status, r.Expr, r.FormatActual())
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d thresholds failed\n", failed, len(results))
+	}
+}
+
+// validate reports every configuration problem and checks that the scenario and
+// recording files load, returning the exit code
+func validate(cfg *config.Config, err error) int {
+	var problems config.ValidationErrors
+	if errors.As(err, &problems) {
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(problems))
+		return 1
+	}
 	if err != nil {
-		log.Fatalf("Error reading config: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Workers get their scenario from the coordinator
+	if cfg.Role != "worker" {
+		scenario, err := generator.LoadScenario(cfg.ScenarioFile, cfg.CollectorURL)
+		if err == nil {
+			err = scenario.CheckMode(cfg.Mode)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "SCENARIO_FILE:", err)
+			return 1
+		}
+		if cfg.Mode == "replay" {
+			if _, err := generator.LoadRecording(cfg.ReplayFile, cfg.ReplayTarget); err != nil {
+				fmt.Fprintln(os.Stderr, "REPLAY_FILE:", err)
+				return 1
+			}
+		}
+	}
+	fmt.Println("Configuration OK")
+	return 0
+}
+
+// runStandalone sends the whole run from this process
+func runStandalone(cfg *config.Config, metrics *generator.Metrics) *generator.Stats {
+	// Load the endpoint mix (defaults to the collector URL with equal method odds)
+	scenario, err := generator.LoadScenario(cfg.ScenarioFile, cfg.CollectorURL)
+	if err != nil {
+		log.Fatalf("Error loading scenario: %v", err)
+	}
+	if err := scenario.CheckMode(cfg.Mode); err != nil {
+		log.Fatalf("Error loading scenario: %v", err)
+	}
+	if cfg.PayloadSize != nil {
+		scenario.SetPayloadSize(cfg.PayloadSize)
+	}
+
+	// Replay mode sends a recorded traffic file instead of the scenario
+	var recording generator.Recording
+	if cfg.Mode == "replay" {
+		recording, err = generator.LoadRecording(cfg.ReplayFile, cfg.ReplayTarget)
+		if err != nil {
+			log.Fatalf("Error loading recording: %v", err)
+		}
+		fmt.Printf("Replaying %d requests recorded over %s\n", len(recording), recording.Duration())
 	}
 
+	// Open the result log (truncated on restart)
+	var resultLog *generator.ResultLog
+	if !cfg.NoResultLog {
+		resultLog, err = generator.OpenResultLog(cfg.ResultLog)
+		if err != nil {
+			log.Fatalf("Error opening result log: %v", err)
+		}
+	}
+
+	// Record every request sent so the run can be replayed later
+	var capture *generator.Capture
+	if cfg.CaptureFile != "" {
+		capture, err = generator.OpenCapture(cfg.CaptureFile, cfg.Seed)
+		if err != nil {
+			log.Fatalf("Error opening capture file: %v", err)
+		}
+		fmt.Println("Capturing requests to", cfg.CaptureFile)
+	}
+
+	// One shared HTTP client for the whole run
+	client := generator.NewClient(cfg.Transport)
+
 	fmt.Println("Starting Traffic Generator...")
-	generator.Simulator(cfg.APICount, cfg.APIRate, cfg.CollectorURL) // ✅ Use `generator.Simulator`
-	fmt.Println("Traffic Generator finished.")
+	if cfg.Arrival != nil {
+		fmt.Printf("Arrival seed: %d\n", cfg.ArrivalSeed)
+	}
+	progress := newProgress(cfg)
+
+	// Operators can pause, retarget or stop the run; Ctrl+C and SIGTERM stop it gracefully
+	control := generator.NewControl()
+	handleSignals(control.Stop)
+	if cfg.ControlListen != "" {
+		generator.ServeControl(cfg.ControlListen, control)
+		fmt.Println("Serving run control on", cfg.ControlListen)
+	}
+
+	stats := generator.Run(generator.Options{
+		Mode:         cfg.Mode,
+		Count:        cfg.APICount,
+		Duration:     cfg.Duration,
+		Interval:     cfg.APIRate,
+		VirtualUsers: cfg.VirtualUsers,
+		ThinkTime:    cfg.ThinkTime,
+		Profile:      cfg.Profile,
+		Arrival:      cfg.Arrival,
+		ResultLog:    resultLog,
+		Capture:      capture,
+		Client:       client,
+		Retry:        cfg.Retry,
+		Replay:       recording,
+		ReplaySpeed:  cfg.ReplaySpeed,
+		Abort:        cfg.Abort,
+		Metrics:      metrics,
+		Progress:     progress,
+		Control:      control,
+		MaxInFlight:  cfg.MaxInFlight,
+		Overflow:     cfg.Overflow,
+	}, scenario)
+	client.CloseIdleConnections()
+
+	if resultLog != nil {
+		if err := resultLog.Close(); err != nil {
+			fmt.Println("Error closing result log:", err)
+		}
+	}
+	if capture != nil {
+		if err := capture.Close(); err != nil {
+			fmt.Println("Error closing capture file:", err)
+		}
+	}
+
+	return stats
+}
+
+// handleSignals calls stop on the first SIGINT or SIGTERM, so the run ends
+// gracefully: requests in flight finish and the report is still written. It
+// quits at once on the second.
+func handleSignals(stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Printf("\nReceived %s, finishing requests in flight (repeat to quit now)\n", sig)
+		stop()
+		<-signals
+		fmt.Println("Quitting without a report")
+		os.Exit(130)
+	}()
+}
+
+// newProgress picks the progress display: redrawn in place on a terminal,
+// plain lines when stdout is redirected, or none
+func newProgress(cfg *config.Config) *generator.Progress {
+	every := cfg.ProgressEvery
+	if every == 0 {
+		every = 10 * time.Second
+	}
+	switch cfg.Progress {
+	case "off":
+		return nil
+	case "live":
+		return generator.NewProgress(os.Stdout, true, every)
+	case "plain":
+		return generator.NewProgress(os.Stdout, false, every)
+	default:
+		return generator.NewProgress(os.Stdout, generator.IsTerminal(os.Stdout), every)
+	}
+}
+
+// serveWorker waits for a coordinator to send run shares; each is sent with
+// this worker's own transport, retry and result log settings
+func serveWorker(cfg *config.Config, metrics *generator.Metrics) {
+	var resultLog *generator.ResultLog
+	if !cfg.NoResultLog {
+		var err error
+		resultLog, err = generator.OpenResultLog(cfg.ResultLog)
+		if err != nil {
+			log.Fatalf("Error opening result log: %v", err)
+		}
+	}
+
+	worker := generator.NewWorker(generator.Options{
+		ResultLog:   resultLog,
+		Client:      generator.NewClient(cfg.Transport),
+		Retry:       cfg.Retry,
+		Metrics:     metrics,
+		MaxInFlight: cfg.MaxInFlight,
+		Overflow:    cfg.Overflow,
+	})
+	worker.PayloadSize = cfg.PayloadSize
+	worker.Token = cfg.WorkerToken
+
+	// A signal stops the share in flight; the worker exits once the coordinator
+	// has its result, or after workerStopGrace
+	server := &http.Server{Addr: cfg.WorkerListen, Handler: worker, ReadHeaderTimeout: 5 * time.Second}
+	handleSignals(func() {
+		worker.Stop()
+		go func() {
+			worker.Wait(workerStopGrace)
+			server.Shutdown(context.Background())
+		}()
+	})
+
+	fmt.Println("Traffic Generator worker listening on", cfg.WorkerListen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	fmt.Println("Traffic Generator worker stopped.")
+}
+
+// workerStopGrace bounds how long a stopping worker waits for the coordinator
+// to fetch its result
+const workerStopGrace = 30 * time.Second
+
+// coordinate splits the run between the configured workers and merges their
+// results, which are partial when err is set
+func coordinate(cfg *config.Config) (*generator.Stats, error) {
+	run := generator.WorkerRun{
+		Seed:         cfg.Seed,
+		BaseURL:      cfg.CollectorURL,
+		Mode:         cfg.Mode,
+		Count:        cfg.APICount,
+		Duration:     cfg.Duration,
+		Interval:     cfg.APIRate,
+		Profile:      cfg.Profile,
+		VirtualUsers: cfg.VirtualUsers,
+		ThinkTime:    cfg.ThinkTime,
+	}
+	if cfg.ScenarioFile != "" {
+		data, err := os.ReadFile(cfg.ScenarioFile)
+		if err != nil {
+			log.Fatalf("Error loading scenario: %v", err)
+		}
+		run.Scenario = string(data)
+		// ${NAME} in the scenario's auth is resolved here, not from each worker's environment
+		run.Env, err = generator.ScenarioEnv(data)
+		if err != nil {
+			log.Fatalf("Error loading scenario: %v", err)
+		}
+	}
+	if cfg.Arrival != nil {
+		run.Arrival = &cfg.ArrivalOpts
+	}
+
+	// Ctrl+C and SIGTERM stop every worker; their results are still merged and reported
+	control := generator.NewControl()
+	handleSignals(control.Stop)
+
+	fmt.Printf("Starting Traffic Generator coordinator with %d workers...\n", len(cfg.Workers))
+	stats, err := (&generator.Coordinator{Workers: cfg.Workers, Token: cfg.WorkerToken, Control: control}).Run(run)
+	if stats == nil {
+		log.Fatalf("Error running workers: %v", err)
+	}
+	fmt.Printf("Requests sent: %d\n", stats.Overall.Total+uint64(stats.Errors))
+	return stats, err
 }
@@ -0,0 +1,94 @@
+package spec
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Arrival decides when requests arrive relative to the target rate.
+// Gaps are measured in requests at the current rate, so a gap of 1 is exactly
+// one interval; processes with a mean gap of 1 keep the average rate on target.
+type Arrival interface {
+	// Next returns the gap until the following request
+	Next() float64
+	// Factor scales the target rate at the given point in the run
+	Factor(elapsed time.Duration) float64
+}
+
+// ArrivalOptions holds the settings for NewArrival
+type ArrivalOptions struct {
+	Kind     string // "constant" (default), "poisson", "jitter" or "burst"
+	Seed     int64
+	Jitter   float64       // jitter: gaps vary uniformly by ±Jitter of an interval
+	BurstOn  time.Duration // burst: length of each sending phase
+	BurstOff time.Duration // burst: length of each silent phase
+}
+
+// NewArrival builds the arrival process described by opts
+func NewArrival(opts ArrivalOptions) (Arrival, error) {
+	rng := rand.New(rand.NewSource(opts.Seed))
+
+	switch opts.Kind {
+	case "", "constant":
+		return ConstantArrival{}, nil
+	case "poisson":
+		return &PoissonArrival{rng: rng}, nil
+	case "jitter":
+		if opts.Jitter < 0 || opts.Jitter > 1 {
+			return nil, fmt.Errorf("jitter must be between 0 and 1")
+		}
+		return &JitterArrival{Jitter: opts.Jitter, rng: rng}, nil
+	case "burst":
+		if opts.BurstOn <= 0 || opts.BurstOff < 0 {
+			return nil, fmt.Errorf("burst needs a positive on period and a non-negative off period")
+		}
+		return &BurstArrival{On: opts.BurstOn, Off: opts.BurstOff, rng: rng}, nil
+	default:
+		return nil, fmt.Errorf("unknown arrival process %q, use constant, poisson, jitter or burst", opts.Kind)
+	}
+}
+
+// ConstantArrival sends at perfectly even intervals
+type ConstantArrival struct{}
+
+func (ConstantArrival) Next() float64                { return 1 }
+func (ConstantArrival) Factor(time.Duration) float64 { return 1 }
+
+// PoissonArrival draws exponential gaps, the arrival pattern of independent clients
+type PoissonArrival struct {
+	rng *rand.Rand
+}
+
+func (p *PoissonArrival) Next() float64                { return p.rng.ExpFloat64() }
+func (p *PoissonArrival) Factor(time.Duration) float64 { return 1 }
+
+// JitterArrival varies each interval uniformly around its nominal length
+type JitterArrival struct {
+	Jitter float64
+	rng    *rand.Rand
+}
+
+func (j *JitterArrival) Next() float64 {
+	return 1 + j.Jitter*(2*j.rng.Float64()-1)
+}
+
+func (j *JitterArrival) Factor(time.Duration) float64 { return 1 }
+
+// BurstArrival alternates between sending and silent phases. Poisson arrivals
+// during the on phase are sped up so the average rate over a cycle stays on target.
+type BurstArrival struct {
+	On  time.Duration
+	Off time.Duration
+	rng *rand.Rand
+}
+
+func (b *BurstArrival) Next() float64 { return b.rng.ExpFloat64() }
+
+func (b *BurstArrival) Factor(elapsed time.Duration) float64 {
+	cycle := b.On + b.Off
+	if elapsed%cycle < b.On {
+		return float64(cycle) / float64(b.On)
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package spec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewArrival_SeededIsReproducible(t *testing.T) {
+	for _, kind := range []string{"poisson", "jitter"} {
+		a, err := NewArrival(ArrivalOptions{Kind: kind, Seed: 7, Jitter: 0.3})
+		assert.NoError(t, err)
+		b, _ := NewArrival(ArrivalOptions{Kind: kind, Seed: 7, Jitter: 0.3})
+
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, a.Next(), b.Next(), kind)
+		}
+	}
+}
+
+func TestNewArrival_MeanGapIsOne(t *testing.T) {
+	for _, kind := range []string{"constant", "poisson", "jitter"} {
+		arrival, err := NewArrival(ArrivalOptions{Kind: kind, Seed: 1, Jitter: 0.5})
+		assert.NoError(t, err)
+
+		sum := 0.0
+		for i := 0; i < 20000; i++ {
+			sum += arrival.Next()
+		}
+		assert.InDelta(t, 1, sum/20000, 0.03, kind)
+	}
+}
+
+func TestJitterArrival_StaysInBounds(t *testing.T) {
+	arrival, _ := NewArrival(ArrivalOptions{Kind: "jitter", Seed: 3, Jitter: 0.2})
+
+	for i := 0; i < 1000; i++ {
+		gap := arrival.Next()
+		assert.True(t, gap >= 0.8 && gap <= 1.2, "gap %v out of bounds", gap)
+	}
+}
+
+func TestBurstArrival_Factor(t *testing.T) {
+	arrival, err := NewArrival(ArrivalOptions{Kind: "burst", BurstOn: time.Second, BurstOff: 3 * time.Second})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 4.0, arrival.Factor(500*time.Millisecond))
+	assert.Equal(t, 0.0, arrival.Factor(2*time.Second))
+	assert.Equal(t, 4.0, arrival.Factor(4*time.Second))
+}
+
+func TestNewArrival_Errors(t *testing.T) {
+	_, err := NewArrival(ArrivalOptions{Kind: "gaussian"})
+	assert.ErrorContains(t, err, "unknown arrival process")
+
+	_, err = NewArrival(ArrivalOptions{Kind: "jitter", Jitter: 2})
+	assert.ErrorContains(t, err, "jitter must be between 0 and 1")
+
+	_, err = NewArrival(ArrivalOptions{Kind: "burst"})
+	assert.ErrorContains(t, err, "positive on period")
+}
@@ -0,0 +1,5 @@
+// Package spec holds the settings a run is described with, and their parsers:
+// rates and load profiles, arrival processes, body size distributions,
+// thresholds, retry, transport and result log options. It sits below config,
+// which reads and checks them, and generator, which runs them.
+package spec
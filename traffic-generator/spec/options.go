@@ -0,0 +1,29 @@
+package spec
+
+import "time"
+
+// TransportOptions tunes the HTTP client shared by all requests of a run.
+// Zero values pick the defaults noted on each field.
+type TransportOptions struct {
+	PoolSize                int           // idle connections kept per host, default 100; connections in use are not capped
+	DisableKeepAlives       bool          // close each connection after one request
+	IdleTimeout             time.Duration // default 90s
+	Timeout                 time.Duration // whole request including body, default 30s
+	TLSHandshakeTimeout     time.Duration // default 10s
+	DisableHTTP2            bool          // stick to HTTP/1.1 even when the server offers h2
+	NewConnectionPerRequest bool          // fresh transport per request, for connection-churn tests
+}
+
+// ResultLogOptions configures the generator's per-request result log
+type ResultLogOptions struct {
+	Path     string
+	MaxSize  int64 // rotate once a file holds this many bytes, 0 to never rotate
+	MaxFiles int   // rotated files to keep
+	Gzip     bool
+}
+
+// Overflow policies for a full worker pool
+const (
+	OverflowDelay = "delay"
+	OverflowDrop  = "drop"
+)
@@ -0,0 +1,252 @@
+package spec
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxPayloadSize caps sampled body sizes so a wide distribution can't exhaust memory
+const MaxPayloadSize = 64 << 20
+
+// SizeDistribution draws the target size in bytes of a request body
+type SizeDistribution interface {
+	Sample(r *rand.Rand) int
+}
+
+// ParseSizeDistribution reads a size distribution spec:
+//
+//	fixed 4KB              (or just 4KB)
+//	uniform 100B 64KB
+//	normal 2KB 512B        mean and standard deviation
+//	lognormal 1KB 0.8      median and sigma of the underlying normal
+//	empirical sizes.txt    histogram file, see LoadEmpiricalSizes
+func ParseSizeDistribution(spec string) (SizeDistribution, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty payload size")
+	}
+	if len(fields) == 1 {
+		fields = []string{"fixed", fields[0]}
+	}
+
+	kind, args := strings.ToLower(fields[0]), fields[1:]
+	switch kind {
+	case "fixed":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("fixed payload size needs one size, e.g. 'fixed 4KB'")
+		}
+		size, err := ParseSize(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return FixedSize(size), nil
+
+	case "uniform":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("uniform payload size needs a min and max, e.g. 'uniform 100B 64KB'")
+		}
+		min, err := ParseSize(args[0])
+		if err != nil {
+			return nil, err
+		}
+		max, err := ParseSize(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if min > max {
+			return nil, fmt.Errorf("uniform payload size: min must not be greater than max")
+		}
+		return &UniformSize{Min: min, Max: max}, nil
+
+	case "normal":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("normal payload size needs a mean and deviation, e.g. 'normal 2KB 512B'")
+		}
+		mean, err := ParseSize(args[0])
+		if err != nil {
+			return nil, err
+		}
+		stddev, err := ParseSize(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &NormalSize{Mean: float64(mean), StdDev: float64(stddev)}, nil
+
+	case "lognormal":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("lognormal payload size needs a median and sigma, e.g. 'lognormal 1KB 0.8'")
+		}
+		median, err := ParseSize(args[0])
+		if err != nil {
+			return nil, err
+		}
+		sigma, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || sigma < 0 {
+			return nil, fmt.Errorf("invalid lognormal sigma %q", args[1])
+		}
+		return &LogNormalSize{Median: float64(median), Sigma: sigma}, nil
+
+	case "empirical":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("empirical payload size needs a histogram file")
+		}
+		return LoadEmpiricalSizes(args[0])
+
+	default:
+		return nil, fmt.Errorf("unknown payload size distribution %q, use fixed, uniform, normal, lognormal or empirical", kind)
+	}
+}
+
+var sizePattern = regexp.MustCompile(`^(\d+)\s*([kKmMgG]?)[bB]?$`)
+
+// ParseSize converts sizes like '512', '64KB', '10MB' or '1GB' into bytes
+func ParseSize(size string) (int64, error) {
+	matches := sizePattern.FindStringSubmatch(strings.TrimSpace(size))
+	if len(matches) != 3 {
+		return 0, fmt.Errorf("invalid size %q, use a size like '512B', '64KB' or '10MB'", size)
+	}
+
+	value, _ := strconv.ParseInt(matches[1], 10, 64)
+	switch strings.ToLower(matches[2]) {
+	case "k":
+		value <<= 10
+	case "m":
+		value <<= 20
+	case "g":
+		value <<= 30
+	}
+	return value, nil
+}
+
+// clampSize keeps a sampled size within 0..MaxPayloadSize
+func clampSize(size float64) int {
+	if size < 0 || math.IsNaN(size) {
+		return 0
+	}
+	if size > MaxPayloadSize {
+		return MaxPayloadSize
+	}
+	return int(size)
+}
+
+// FixedSize always returns the same size
+type FixedSize int64
+
+func (f FixedSize) Sample(*rand.Rand) int {
+	return clampSize(float64(f))
+}
+
+// UniformSize is uniform over Min..Max inclusive
+type UniformSize struct {
+	Min, Max int64
+}
+
+func (u *UniformSize) Sample(r *rand.Rand) int {
+	return clampSize(float64(u.Min + r.Int63n(u.Max-u.Min+1)))
+}
+
+// NormalSize is normally distributed, cut off at zero
+type NormalSize struct {
+	Mean, StdDev float64
+}
+
+func (n *NormalSize) Sample(r *rand.Rand) int {
+	return clampSize(math.Round(n.Mean + r.NormFloat64()*n.StdDev))
+}
+
+// LogNormalSize is log-normally distributed around Median. It has the long
+// right tail real request sizes tend to show.
+type LogNormalSize struct {
+	Median, Sigma float64
+}
+
+func (l *LogNormalSize) Sample(r *rand.Rand) int {
+	return clampSize(math.Round(l.Median * math.Exp(r.NormFloat64()*l.Sigma)))
+}
+
+// EmpiricalSizes samples from a histogram of observed sizes
+type EmpiricalSizes struct {
+	Buckets    []SizeBucket
+	cumulative []int // running total of the bucket weights
+}
+
+// SizeBucket is one histogram line: sizes in Min..Max seen Weight times
+type SizeBucket struct {
+	Min, Max int64
+	Weight   int
+}
+
+// LoadEmpiricalSizes reads a histogram file. Each line holds a size or a size
+// range and its weight; blank lines and lines starting with # are skipped:
+//
+//	# size      weight
+//	200B        50
+//	1KB-4KB     30
+//	64KB        2
+func LoadEmpiricalSizes(path string) (*EmpiricalSizes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read size histogram: %w", err)
+	}
+	defer f.Close()
+
+	e := &EmpiricalSizes{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(strings.ReplaceAll(text, ",", " "))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected '<size> <weight>'", path, line)
+		}
+		weight, err := strconv.Atoi(fields[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid weight %q", path, line, fields[1])
+		}
+
+		bounds := strings.SplitN(fields[0], "-", 2)
+		min, err := ParseSize(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		max := min
+		if len(bounds) == 2 {
+			if max, err = ParseSize(bounds[1]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			if min > max {
+				return nil, fmt.Errorf("%s:%d: range minimum is greater than maximum", path, line)
+			}
+		}
+
+		e.Buckets = append(e.Buckets, SizeBucket{Min: min, Max: max, Weight: weight})
+		total := weight
+		if n := len(e.cumulative); n > 0 {
+			total += e.cumulative[n-1]
+		}
+		e.cumulative = append(e.cumulative, total)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read size histogram: %w", err)
+	}
+	if len(e.cumulative) == 0 || e.cumulative[len(e.cumulative)-1] == 0 {
+		return nil, fmt.Errorf("%s: size histogram has no weighted entries", path)
+	}
+	return e, nil
+}
+
+func (e *EmpiricalSizes) Sample(r *rand.Rand) int {
+	n := r.Intn(e.cumulative[len(e.cumulative)-1])
+	b := e.Buckets[sort.SearchInts(e.cumulative, n+1)]
+	return clampSize(float64(b.Min + r.Int63n(b.Max-b.Min+1)))
+}
@@ -0,0 +1,80 @@
+package spec
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{"512": 512, "200B": 200, "64KB": 64 << 10, "10mb": 10 << 20, "1G": 1 << 30}
+	for input, want := range cases {
+		got, err := ParseSize(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	_, err := ParseSize("lots")
+	assert.Error(t, err)
+}
+
+func TestParseSizeDistribution(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	fixed, err := ParseSizeDistribution("4KB")
+	assert.NoError(t, err)
+	assert.Equal(t, 4096, fixed.Sample(r))
+
+	uniform, err := ParseSizeDistribution("uniform 100B 200B")
+	assert.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		size := uniform.Sample(r)
+		assert.True(t, size >= 100 && size <= 200, size)
+	}
+
+	for _, spec := range []string{"normal 2KB 512B", "lognormal 2KB 0.5"} {
+		dist, err := ParseSizeDistribution(spec)
+		assert.NoError(t, err, spec)
+
+		samples := make([]int, 5001)
+		for i := range samples {
+			samples[i] = dist.Sample(r)
+			assert.GreaterOrEqual(t, samples[i], 0)
+		}
+		sort.Ints(samples)
+		assert.InDelta(t, 2048, samples[len(samples)/2], 100, spec)
+	}
+
+	for _, spec := range []string{"", "uniform 10KB 1KB", "normal 2KB", "lognormal 1KB wide", "pareto 1KB", "empirical /does/not/exist"} {
+		_, err := ParseSizeDistribution(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestLoadEmpiricalSizes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sizes.txt")
+	os.WriteFile(path, []byte("# size weight\n100B 3\n\n1KB-2KB 1\n64KB 0\n"), 0o644)
+
+	dist, err := ParseSizeDistribution("empirical " + path)
+	assert.NoError(t, err)
+
+	r := rand.New(rand.NewSource(1))
+	small := 0
+	for i := 0; i < 4000; i++ {
+		size := dist.Sample(r)
+		if size == 100 {
+			small++
+		} else {
+			assert.True(t, size >= 1024 && size <= 2048, size)
+		}
+	}
+	assert.InDelta(t, 3000, small, 150)
+
+	os.WriteFile(path, []byte("100B many\n"), 0o644)
+	_, err = LoadEmpiricalSizes(path)
+	assert.ErrorContains(t, err, "sizes.txt:1: invalid weight")
+}
@@ -0,0 +1,170 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stage is one segment of a load profile
+type Stage struct {
+	Kind     string // "ramp", "hold", "step" or "spike"
+	Rate     float64
+	Duration time.Duration
+
+	from  float64 // rate at the start of the stage
+	after float64 // rate carried into the next stage
+}
+
+// Profile is a staged load profile, rates are in requests per second
+type Profile struct {
+	Stages []Stage
+}
+
+var rateRe = regexp.MustCompile(`^(\d+(?:\.\d+)?)/([smhSMH])$`)
+
+// ParseRatePerSecond converts a rate like '5/s', '100/m' or '3000/h' into requests per second
+func ParseRatePerSecond(rate string) (float64, error) {
+	matches := rateRe.FindStringSubmatch(rate)
+	if len(matches) != 3 {
+		return 0, fmt.Errorf("invalid rate %q, use '2/s', '100/m', or '3000/h'", rate)
+	}
+
+	value, _ := strconv.ParseFloat(matches[1], 64)
+	switch strings.ToLower(matches[2]) {
+	case "m":
+		value /= 60
+	case "h":
+		value /= 3600
+	}
+	return value, nil
+}
+
+// ParseProfile parses a profile such as
+//
+//	ramp 100/s 2m, hold 10m, spike 500/s 30s, ramp 0/s 1m
+//
+// starting from startRate requests per second. Stage kinds:
+//   - ramp <rate> <duration>: linear change from the current rate to <rate>
+//   - hold|soak <duration>: keep the current rate
+//   - step <rate> <duration>: jump to <rate> and keep it
+//   - spike <rate> <duration>: jump to <rate>, then return to the previous rate
+func ParseProfile(spec string, startRate float64) (*Profile, error) {
+	profile := &Profile{}
+	current := startRate
+
+	for i, part := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ';' }) {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+
+		stage := Stage{Kind: strings.ToLower(fields[0]), from: current}
+		var durationField string
+
+		switch stage.Kind {
+		case "hold", "soak":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("stage %d: use '%s <duration>'", i+1, stage.Kind)
+			}
+			stage.Kind = "hold"
+			stage.Rate = current
+			durationField = fields[1]
+		case "ramp", "step", "spike":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("stage %d: use '%s <rate> <duration>'", i+1, stage.Kind)
+			}
+			rate, err := ParseRatePerSecond(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: %w", i+1, err)
+			}
+			stage.Rate = rate
+			durationField = fields[2]
+		default:
+			return nil, fmt.Errorf("stage %d: unknown stage %q, use ramp, hold, soak, step or spike", i+1, fields[0])
+		}
+
+		duration, err := time.ParseDuration(durationField)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("stage %d: invalid duration %q", i+1, durationField)
+		}
+		stage.Duration = duration
+
+		if stage.Kind == "spike" {
+			stage.after = current
+		} else {
+			stage.after = stage.Rate
+		}
+		current = stage.after
+
+		profile.Stages = append(profile.Stages, stage)
+	}
+
+	if len(profile.Stages) == 0 {
+		return nil, fmt.Errorf("load profile has no stages")
+	}
+
+	return profile, nil
+}
+
+// Duration is the total length of the profile
+func (p *Profile) Duration() time.Duration {
+	var total time.Duration
+	for _, stage := range p.Stages {
+		total += stage.Duration
+	}
+	return total
+}
+
+// RateAt returns the target rate in requests per second at the given offset into the run
+func (p *Profile) RateAt(elapsed time.Duration) float64 {
+	for _, stage := range p.Stages {
+		if elapsed < stage.Duration {
+			if stage.Kind == "ramp" {
+				progress := float64(elapsed) / float64(stage.Duration)
+				return stage.from + (stage.Rate-stage.from)*progress
+			}
+			return stage.Rate
+		}
+		elapsed -= stage.Duration
+	}
+	return 0
+}
+
+// Scale returns a copy of the profile with every rate multiplied by factor
+func (p *Profile) Scale(factor float64) *Profile {
+	scaled := &Profile{Stages: make([]Stage, len(p.Stages))}
+	for i, stage := range p.Stages {
+		stage.Rate *= factor
+		stage.from *= factor
+		stage.after *= factor
+		scaled.Stages[i] = stage
+	}
+	return scaled
+}
+
+// stageJSON carries a stage, including the rates it starts from and hands on,
+// so a parsed profile can be sent to a worker
+type stageJSON struct {
+	Kind     string        `json:"kind"`
+	Rate     float64       `json:"rate"`
+	Duration time.Duration `json:"duration"`
+	From     float64       `json:"from"`
+	After    float64       `json:"after"`
+}
+
+func (s Stage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(stageJSON{Kind: s.Kind, Rate: s.Rate, Duration: s.Duration, From: s.from, After: s.after})
+}
+
+func (s *Stage) UnmarshalJSON(data []byte) error {
+	var j stageJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = Stage{Kind: j.Kind, Rate: j.Rate, Duration: j.Duration, from: j.From, after: j.After}
+	return nil
+}
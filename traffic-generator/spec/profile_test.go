@@ -0,0 +1,52 @@
+package spec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProfile_Interpolation(t *testing.T) {
+	profile, err := ParseProfile("ramp 100/s 2m, hold 10m; spike 500/s 30s, ramp 0/s 1m", 0)
+
+	assert.NoError(t, err)
+	assert.Len(t, profile.Stages, 4)
+	assert.Equal(t, 13*time.Minute+30*time.Second, profile.Duration())
+
+	assert.InDelta(t, 0, profile.RateAt(0), 0.001)
+	assert.InDelta(t, 50, profile.RateAt(time.Minute), 0.001)
+	assert.InDelta(t, 100, profile.RateAt(5*time.Minute), 0.001)
+	assert.InDelta(t, 500, profile.RateAt(12*time.Minute+10*time.Second), 0.001)
+	// after the spike the ramp-down starts from the pre-spike rate
+	assert.InDelta(t, 50, profile.RateAt(13*time.Minute), 0.001)
+	assert.Zero(t, profile.RateAt(time.Hour))
+}
+
+func TestParseProfile_StartRateAndStep(t *testing.T) {
+	profile, err := ParseProfile("step 120/m 10s, soak 10s", 5)
+
+	assert.NoError(t, err)
+	assert.InDelta(t, 2, profile.RateAt(0), 0.001)
+	assert.InDelta(t, 2, profile.RateAt(15*time.Second), 0.001)
+}
+
+func TestParseProfile_Errors(t *testing.T) {
+	cases := map[string]string{
+		"":                     "no stages",
+		"ramp 10/s":            "use 'ramp <rate> <duration>'",
+		"hold":                 "use 'hold <duration>'",
+		"ramp fast 1m":         "invalid rate",
+		"hold forever":         "invalid duration",
+		"climb 10/s 1m":        "unknown stage",
+		"step 10/s 1m, hold x": "stage 2",
+	}
+
+	for spec, expected := range cases {
+		profile, err := ParseProfile(spec, 0)
+		assert.Nil(t, profile, spec)
+		if assert.Error(t, err, spec) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
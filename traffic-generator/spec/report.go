@@ -0,0 +1,21 @@
+package spec
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ReportFormat infers the report format from the file extension
+func ReportFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json", nil
+	case ".csv":
+		return "csv", nil
+	case ".html", ".htm":
+		return "html", nil
+	default:
+		return "", fmt.Errorf("cannot tell report format from %q, use .json, .csv or .html", path)
+	}
+}
@@ -0,0 +1,16 @@
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportFormat(t *testing.T) {
+	format, err := ReportFormat("out/summary.HTML")
+	assert.NoError(t, err)
+	assert.Equal(t, "html", format)
+
+	_, err = ReportFormat("summary.txt")
+	assert.Error(t, err)
+}
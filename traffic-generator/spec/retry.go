@@ -0,0 +1,21 @@
+package spec
+
+import "time"
+
+// RetryPolicy decides whether a failed attempt is repeated and how long to wait first
+type RetryPolicy struct {
+	MaxAttempts     int           // attempts including the first, 1 disables retries
+	InitialBackoff  time.Duration // wait before the first retry, doubled for each one after
+	MaxBackoff      time.Duration // cap for the exponential backoff, 0 for none
+	Jitter          float64       // 0..1, fraction of each backoff that is randomized
+	Statuses        map[int]bool  // response codes worth retrying
+	ErrorClasses    map[string]bool
+	HonorRetryAfter bool          // wait as told by Retry-After on 429 and 503
+	MaxRetryAfter   time.Duration // ignore Retry-After values longer than this
+}
+
+// DefaultRetryStatuses are retried when no status list is configured
+var DefaultRetryStatuses = map[int]bool{429: true, 502: true, 503: true, 504: true}
+
+// DefaultRetryErrors are the error classes retried when none are configured
+var DefaultRetryErrors = map[string]bool{"timeout": true, "connection_refused": true, "connection_reset": true, "eof": true}
@@ -0,0 +1,16 @@
+package spec
+
+import "hash/fnv"
+
+// DeriveSeed returns the seed of a named random stream of a run, so each
+// stream is reproducible without drawing from the others
+func DeriveSeed(seed int64, stream string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(stream))
+
+	// splitmix64 finalizer spreads nearby seeds apart
+	z := uint64(seed) ^ h.Sum64()
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
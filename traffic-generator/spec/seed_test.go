@@ -0,0 +1,13 @@
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveSeed(t *testing.T) {
+	assert.Equal(t, DeriveSeed(1, "arrival"), DeriveSeed(1, "arrival"))
+	assert.NotEqual(t, DeriveSeed(1, "arrival"), DeriveSeed(1, "retry"))
+	assert.NotEqual(t, DeriveSeed(1, "arrival"), DeriveSeed(2, "arrival"))
+}
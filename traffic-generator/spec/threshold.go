@@ -0,0 +1,129 @@
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold is a pass/fail condition on the results of a run, like "p95 < 300ms"
+type Threshold struct {
+	Expr   string  `json:"expr"`
+	Metric string  `json:"metric"` // a percentile like p95, mean, min, max, error_rate, rps or requests
+	Op     string  `json:"op"`     // <, <=, > or >=
+	Value  float64 `json:"value"`  // milliseconds for latencies, a fraction for error_rate (judged on each request's final attempt)
+}
+
+var thresholdRe = regexp.MustCompile(`^([a-z_]+|p\d+(?:\.\d+)?)\s*(<=|>=|<|>)\s*(\S+)$`)
+
+// ParseThresholds reads a comma-separated list of expressions such as
+//
+//	p95 < 300ms, p99.9 < 1s, mean < 100ms, error_rate < 1%, rps > 400, requests >= 10000
+//
+// Latencies take a duration (a bare number is milliseconds), error_rate a
+// percentage or fraction, rps a number or rate like 400/s.
+func ParseThresholds(spec string) ([]Threshold, error) {
+	var thresholds []Threshold
+	for _, expr := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ';' }) {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+		matches := thresholdRe.FindStringSubmatch(strings.ToLower(expr))
+		if matches == nil {
+			return nil, fmt.Errorf("threshold %q: use '<metric> <op> <value>', e.g. 'p95 < 300ms'", expr)
+		}
+
+		t := Threshold{Expr: expr, Metric: matches[1], Op: matches[2]}
+		value, err := thresholdValue(t.Metric, matches[3])
+		if err != nil {
+			return nil, fmt.Errorf("threshold %q: %w", expr, err)
+		}
+		t.Value = value
+		thresholds = append(thresholds, t)
+	}
+	if len(thresholds) == 0 {
+		return nil, fmt.Errorf("no thresholds")
+	}
+	return thresholds, nil
+}
+
+// thresholdValue converts the right-hand side of an expression into the metric's unit
+func thresholdValue(metric, value string) (float64, error) {
+	switch {
+	case isLatencyMetric(metric):
+		if strings.HasPrefix(metric, "p") {
+			p, _ := strconv.ParseFloat(metric[1:], 64)
+			if p <= 0 || p > 100 {
+				return 0, fmt.Errorf("percentile must be between 0 and 100")
+			}
+		}
+		if ms, err := strconv.ParseFloat(value, 64); err == nil {
+			return ms, nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid latency %q, use a duration like '300ms'", value)
+		}
+		return float64(d) / float64(time.Millisecond), nil
+
+	case metric == "error_rate":
+		if strings.HasSuffix(value, "%") {
+			pct, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid error rate %q", value)
+			}
+			return pct / 100, nil
+		}
+		fraction, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid error rate %q, use a percentage like '1%%'", value)
+		}
+		return fraction, nil
+
+	case metric == "rps":
+		if strings.Contains(value, "/") {
+			return ParseRatePerSecond(value)
+		}
+		rps, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rate %q", value)
+		}
+		return rps, nil
+
+	case metric == "requests":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid request count %q", value)
+		}
+		return float64(n), nil
+
+	default:
+		return 0, fmt.Errorf("unknown metric %q, use pNN, mean, min, max, error_rate, rps or requests", metric)
+	}
+}
+
+func isLatencyMetric(metric string) bool {
+	return metric == "mean" || metric == "min" || metric == "max" || strings.HasPrefix(metric, "p")
+}
+
+// Latency reports whether the threshold is on a latency, measured in milliseconds
+func (t Threshold) Latency() bool {
+	return isLatencyMetric(t.Metric)
+}
+
+// Abortable reports whether a failure part way through a run predicts the final
+// result. Rates and counts are still climbing, so only latency and errors count.
+func (t Threshold) Abortable() bool {
+	return t.Latency() || t.Metric == "error_rate"
+}
+
+// ThresholdAbort stops a run as soon as a latency or error threshold fails,
+// once the grace period has passed and enough requests have completed
+type ThresholdAbort struct {
+	Thresholds []Threshold
+	Grace      time.Duration // no checks before this much of the run has passed
+	MinSamples int64         // no checks before this many requests have completed, default 100
+}
@@ -0,0 +1,26 @@
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThresholds(t *testing.T) {
+	thresholds, err := ParseThresholds("p95 < 300ms, p99.9<=1s; mean < 50, error_rate < 1%, rps > 400/s, requests >= 1000")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Threshold{
+		{Expr: "p95 < 300ms", Metric: "p95", Op: "<", Value: 300},
+		{Expr: "p99.9<=1s", Metric: "p99.9", Op: "<=", Value: 1000},
+		{Expr: "mean < 50", Metric: "mean", Op: "<", Value: 50},
+		{Expr: "error_rate < 1%", Metric: "error_rate", Op: "<", Value: 0.01},
+		{Expr: "rps > 400/s", Metric: "rps", Op: ">", Value: 400},
+		{Expr: "requests >= 1000", Metric: "requests", Op: ">=", Value: 1000},
+	}, thresholds)
+
+	for _, spec := range []string{"", "p95", "p95 = 300ms", "p0 < 1s", "p101 < 1s", "latency < 1s", "p95 < soon", "error_rate < lots", "requests > 1.5"} {
+		_, err := ParseThresholds(spec)
+		assert.Error(t, err, spec)
+	}
+}
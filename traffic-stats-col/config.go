@@ -26,18 +26,78 @@ type DatabaseConfig struct {
 	SSLMode  string `yaml:"sslmode"`
 }
 
-// LoadConfig reads and parses config.yaml
-func LoadConfig(path string) (*Config, error) {
+// DefaultConfig returns the built-in defaults, the lowest configuration layer
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{Port: "8080"},
+		Database: DatabaseConfig{
+			Host:    "localhost",
+			Port:    "5432",
+			User:    "postgres",
+			DBName:  "traffic_data_20_mar",
+			SSLMode: "disable",
+		},
+	}
+}
+
+// LoadConfig reads the config file over the built-in defaults. A missing file
+// is an error only when required, so env variables alone can configure the server.
+func LoadConfig(path string, required bool) (*Config, error) {
+	cfg := DefaultConfig()
+
 	file, err := os.ReadFile(path)
 	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return cfg, nil
+		}
 		return nil, fmt.Errorf("unable to read config file: %w", err)
 	}
 
-	var cfg Config
-	err = yaml.Unmarshal(file, &cfg)
+	err = yaml.Unmarshal(file, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse config file: %w", err)
 	}
 
-	return &cfg, nil
+	return cfg, nil
+}
+
+// fields maps each environment variable the deployment manifests set to its config field
+func (cfg *Config) fields() map[string]*string {
+	return map[string]*string{
+		"SERVER_PORT": &cfg.Server.Port,
+		"DB_HOST":     &cfg.Database.Host,
+		"DB_PORT":     &cfg.Database.Port,
+		"DB_USER":     &cfg.Database.User,
+		"DB_PASSWORD": &cfg.Database.Password,
+		"DB_NAME":     &cfg.Database.DBName,
+		"DB_SSLMODE":  &cfg.Database.SSLMode,
+	}
+}
+
+// ApplyEnv overrides fields with the environment variables that are set
+func (cfg *Config) ApplyEnv(getenv func(string) string) {
+	for name, field := range cfg.fields() {
+		if v := getenv(name); v != "" {
+			*field = v
+		}
+	}
+}
+
+// Redacted returns a copy that is safe to print
+func (cfg *Config) Redacted() Config {
+	redacted := *cfg
+	if redacted.Database.Password != "" {
+		redacted.Database.Password = "******"
+	}
+	return redacted
+}
+
+// ApplyFlags overrides fields with the command line flags that were given
+func (cfg *Config) ApplyFlags(flags *Config) {
+	given := flags.fields()
+	for name, field := range cfg.fields() {
+		if v := *given[name]; v != "" {
+			*field = v
+		}
+	}
 }
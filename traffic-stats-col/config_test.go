@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig_Layers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("database:\n  host: \"file-host\"\n  password: \"file-pass\"\nserver:\n  port: \"9090\"\n"), 0644)
+
+	cfg, err := LoadConfig(path, true)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Database.Host != "file-host" || cfg.Database.SSLMode != "disable" {
+		t.Fatalf("file values should override defaults only where set, got %+v", cfg.Database)
+	}
+
+	env := map[string]string{"DB_HOST": "postgres-db", "DB_NAME": "traffic"}
+	cfg.ApplyEnv(func(name string) string { return env[name] })
+	cfg.ApplyFlags(&Config{Server: ServerConfig{Port: "7070"}})
+
+	if cfg.Database.Host != "postgres-db" || cfg.Database.DBName != "traffic" || cfg.Database.Password != "file-pass" {
+		t.Fatalf("env should override the file, got %+v", cfg.Database)
+	}
+	if cfg.Server.Port != "7070" {
+		t.Fatalf("flags should override everything, got port %s", cfg.Server.Port)
+	}
+	if redacted := cfg.Redacted(); redacted.Database.Password == "file-pass" || cfg.Database.Password != "file-pass" {
+		t.Fatalf("Redacted should mask a copy of the password")
+	}
+}
+
+func TestLoadConfig_MissingFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+	cfg, err := LoadConfig(missing, false)
+	if err != nil || cfg.Server.Port != "8080" {
+		t.Fatalf("a missing optional file should leave the defaults, got %+v, %v", cfg, err)
+	}
+	if _, err := LoadConfig(missing, true); err == nil {
+		t.Fatalf("a missing required file should fail")
+	}
+}
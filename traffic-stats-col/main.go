@@ -3,7 +3,11 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +16,36 @@ import (
 )
 
 func main() {
+	// Configuration layers: defaults, then the config file, environment variables and flags
+	configFile := flag.String("config", "", "config file (default config.yaml, skipped when missing)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flagCfg := &Config{}
+	flag.StringVar(&flagCfg.Server.Port, "port", "", "port to listen on")
+	flag.StringVar(&flagCfg.Database.Host, "db-host", "", "database host")
+	flag.StringVar(&flagCfg.Database.Port, "db-port", "", "database port")
+	flag.StringVar(&flagCfg.Database.User, "db-user", "", "database user")
+	flag.StringVar(&flagCfg.Database.Password, "db-password", "", "database password")
+	flag.StringVar(&flagCfg.Database.DBName, "db-name", "", "database name")
+	flag.StringVar(&flagCfg.Database.SSLMode, "db-sslmode", "", "database sslmode")
+	flag.Parse()
+
+	path := *configFile
+	if path == "" {
+		path = "config.yaml"
+	}
+	cfg, err := LoadConfig(path, *configFile != "")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	cfg.ApplyEnv(os.Getenv)
+	cfg.ApplyFlags(flagCfg)
+
+	if *printConfig {
+		out, _ := yaml.Marshal(cfg.Redacted())
+		fmt.Print(string(out))
+		return
+	}
+
 	// Initialize logger
 	InitLogger()
 	defer logger.Sync()
@@ -21,15 +55,9 @@ func main() {
 		logger.Fatal("Failed to clear logs file", zap.Error(err))
 	}
 
-	// Load configuration
-	cfg, err := LoadConfig("config.yaml")
-	if err != nil {
-		logger.Fatal("Failed to load configuration", zap.Error(err))
-	}
-
 	// Validate port
 	if cfg.Server.Port == "" {
-		logger.Fatal("Port must be specified in config.yaml")
+		logger.Fatal("Port must be specified in config.yaml, SERVER_PORT or -port")
 	}
 	serverAddress := ":" + cfg.Server.Port
 